@@ -0,0 +1,58 @@
+// Replacement of files surviving crashes.
+//
+// A file is written to a temporary file in the same directory, which is
+// synced and renamed over the file, and the directory is synced then. After
+// a crash the file has either its old or its new content.
+package atomicfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// WriteJSON replaces the file with JSON encoding of v.
+func WriteJSON(fileName string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return Write(fileName, data)
+}
+
+// Write replaces the file with data.
+func Write(fileName string, data []byte) error {
+	dir := filepath.Dir(fileName)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(fileName)+"-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), fileName)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs the directory, so that a file renamed in it persists.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "test.json")
+	for _, value := range []string{"old", "new"} {
+		if err := WriteJSON(fileName, []string{value}); err != nil {
+			t.Fatalf("WriteJSON failed: %q", err)
+		}
+		if data, err := os.ReadFile(fileName); err != nil || string(data) != `["`+value+`"]` {
+			t.Fatalf("Unexpected content %q with %v", data, err)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("Unexpected files %v with %v", entries, err)
+	}
+	if err := WriteJSON(filepath.Join(dir, "missing", "test.json"), nil); err == nil {
+		t.Fatal("Expected writing to missing directory to fail")
+	}
+}
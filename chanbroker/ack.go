@@ -0,0 +1,177 @@
+package chanbroker
+
+import (
+	"crypto/rand"
+	"strconv"
+	"time"
+)
+
+// Delivery is a message delivered to a group member acknowledging messages.
+// Unless it is acknowledged by Ack within AckTimeout, the message is
+// delivered again, to another member of the group if there is one. ID is
+// random and known to the member only. Every attempt has new ID of the
+// attempt, a dash and random text, so that only the member the message was
+// delivered to last may acknowledge it.
+type Delivery struct {
+	ID      string
+	Attempt int
+	Message interface{}
+}
+
+// DeadLetter is a message not acknowledged after MaxDeliveryAttempts
+// deliveries. It is published to the topic of the message suffixed by
+// DeadLetterSuffix.
+type DeadLetter struct {
+	DeadLetterTopic string
+	Attempts        int
+	Message         interface{}
+}
+
+func (m DeadLetter) Topic() string {
+	return m.DeadLetterTopic
+}
+
+// inFlight is a delivered message waiting for acknowledgement.
+type inFlight struct {
+	msg      interface{}
+	topic    string
+	group    string
+	member   chan interface{}
+	attempts int
+	deadline time.Time
+}
+
+type ackRequest struct {
+	topic   string
+	id      string
+	replyCh chan bool
+}
+
+// SubscribeGroupAck returns channel of the topic group subscription like
+// SubscribeGroup receiving messages as Delivery which must be acknowledged.
+func (b *Broker) SubscribeGroupAck(topic string, group string) chan interface{} {
+	return b.subscribe(subscription{topic: topic, group: group, ack: true})
+}
+
+// Ack acknowledges the delivery of the topic message. Returns false if no
+// such delivery waits for acknowledgement or the broker is shutting down.
+func (b *Broker) Ack(topic string, id string) bool {
+	if !b.admit() {
+		return false
+	}
+	replyCh := make(chan bool, 1)
+	b.eventCh <- event{
+		eventType: eventAck,
+		content:   ackRequest{topic: topic, id: id, replyCh: replyCh},
+	}
+	b.publishers.Done()
+	return <-replyCh
+}
+
+// deliverToGroup delivers the message to a single member of the group
+// keeping it in flight if the member acknowledges messages.
+func (s *brokerState) deliverToGroup(topic string, name string, group *consumerGroup, msg interface{}) {
+	member := group.pick(s.broker.options.GroupBalancing)
+	if !s.subs[member].ack {
+		member <- msg
+		return
+	}
+	id := deliveryID(1)
+	s.inFlight[id] = &inFlight{
+		msg:      msg,
+		topic:    topic,
+		group:    name,
+		member:   member,
+		attempts: 1,
+		deadline: time.Now().Add(s.broker.options.AckTimeout),
+	}
+	member <- Delivery{ID: id, Attempt: 1, Message: msg}
+}
+
+// deliveryID returns new random id of the delivery attempt.
+func deliveryID(attempt int) string {
+	return strconv.Itoa(attempt) + "-" + rand.Text()
+}
+
+func (s *brokerState) ack(request ackRequest) bool {
+	if f, ok := s.inFlight[request.id]; ok && f.topic == request.topic {
+		delete(s.inFlight, request.id)
+		return true
+	}
+	return false
+}
+
+// releaseInFlight makes messages delivered to the unsubscribed member due
+// for redelivery.
+func (s *brokerState) releaseInFlight(msgCh chan interface{}) {
+	for _, f := range s.inFlight {
+		if f.member == msgCh {
+			f.deadline = time.Time{}
+		}
+	}
+}
+
+// redeliverUnacknowledged delivers messages not acknowledged in time again
+// or publishes them as dead letters after MaxDeliveryAttempts. Expired
+// messages are discarded. Finding no member of the group counts as an
+// attempt, without MaxDeliveryAttempts such messages are dead letters if no
+// member joins within AckTimeout.
+func (s *brokerState) redeliverUnacknowledged(now time.Time) {
+	options := s.broker.options
+	var due []string
+	for id, f := range s.inFlight {
+		if !now.Before(f.deadline) {
+			due = append(due, id)
+		}
+	}
+	for _, id := range due {
+		f := s.inFlight[id]
+		if MessageExpired(f.msg, now) {
+			delete(s.inFlight, id)
+			continue
+		}
+		if options.MaxDeliveryAttempts > 0 && f.attempts >= options.MaxDeliveryAttempts {
+			s.deadLetter(id, f, now)
+			continue
+		}
+		var group *consumerGroup
+		if topic, ok := s.topics[f.topic]; ok {
+			group = topic.groups[f.group]
+		}
+		if group == nil {
+			if options.MaxDeliveryAttempts <= 0 && f.member == nil {
+				s.deadLetter(id, f, now)
+				continue
+			}
+			f.attempts++
+			f.member = nil
+			f.deadline = now.Add(options.AckTimeout)
+			continue
+		}
+		member := group.pickExcept(options.GroupBalancing, f.member)
+		s.broker.metrics.redelivered.Inc(f.topic)
+		delete(s.inFlight, id)
+		if !s.subs[member].ack {
+			member <- f.msg
+			continue
+		}
+		f.attempts++
+		f.member = member
+		f.deadline = now.Add(options.AckTimeout)
+		id = deliveryID(f.attempts)
+		s.inFlight[id] = f
+		member <- Delivery{ID: id, Attempt: f.attempts, Message: f.msg}
+	}
+}
+
+// deadLetter publishes the message in flight as dead letter unless
+// DeadLetterSuffix is empty.
+func (s *brokerState) deadLetter(id string, f *inFlight, now time.Time) {
+	options := s.broker.options
+	delete(s.inFlight, id)
+	s.broker.metrics.deadLettered.Inc(f.topic)
+	if options.DeadLetterSuffix != "" {
+		s.publish(DeadLetter{DeadLetterTopic: f.topic + options.DeadLetterSuffix, Attempts: f.attempts,
+			Message: f.msg}, now)
+	}
+}
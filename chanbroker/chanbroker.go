@@ -3,71 +3,205 @@
 // The Broker uses single chan for subscribe, unsubscribe and publish events.
 // That ensures proper serialization during event processing what
 // simplifies test predictability.
+//
+// Messages implementing TopicMessage are delivered only to subscribers of
+// the message topic and to subscribers of all topics. Other messages are
+// delivered to every subscriber. Subscribers of a topic consumer group share
+// messages, every message is delivered to a single member of the group.
+// Members acknowledging messages receive them as Delivery and get them
+// delivered again until they acknowledge them. The last message of a topic
+// implementing RetainedMessage is kept and delivered to new subscribers of
+// the topic.
 package chanbroker
 
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 type eventType int
 
 const (
 	eventSubscribe eventType = iota
 	eventUnsubscribe
 	eventPublish
+	eventTopics
+	eventTopicControl
+	eventShutdown
+	eventPing
+	eventAck
+	eventPresence
 )
 
 type event struct {
 	eventType eventType
 	content   interface{}
+	created   time.Time
+}
+
+// TopicMessage is implemented by messages which belong to a single topic.
+type TopicMessage interface {
+	Topic() string
+}
+
+type subscription struct {
+	msgCh     chan interface{}
+	topic     string
+	allTopics bool
+	// group is the consumer group name of the topic subscription or empty.
+	group string
+	// ack is true if the group member acknowledges messages.
+	ack bool
+	// clientID is the id of the client present in the topic or empty.
+	clientID string
+}
+
+// Options configure channel buffer sizes of the Broker.
+type Options struct {
+	// EventQueueSize is the buffer size of subscribe, unsubscribe and
+	// publish events waiting to be processed.
+	EventQueueSize int
+	// SubscriberBufferSize is the buffer size of messages delivered to a
+	// subscriber but not received yet.
+	SubscriberBufferSize int
+	// GroupBalancing selects consumer group members receiving messages.
+	GroupBalancing Balancing
+	// AckTimeout is the time a group member acknowledging messages has to
+	// acknowledge a delivered message before it is delivered again.
+	AckTimeout time.Duration
+	// MaxDeliveryAttempts limits deliveries of a message not acknowledged
+	// if it is positive.
+	MaxDeliveryAttempts int
+	// DeadLetterSuffix makes the topic messages not acknowledged after
+	// MaxDeliveryAttempts are published to. They are discarded if it is
+	// empty.
+	DeadLetterSuffix string
+	// FirstSubscriber is called when a topic gets its first subscriber and
+	// LastUnsubscribed when the last one unsubscribes unless they are nil.
+	// They are called by the Start goroutine, so they must neither block
+	// nor use the broker.
+	FirstSubscriber  func(topic string)
+	LastUnsubscribed func(topic string)
+	// Published is called with every message published to an open topic
+	// unless it is nil. It is called by the Start goroutine too.
+	Published func(msg interface{})
+	// TopicDiscarded is called by the Start goroutine when state of an
+	// idle topic is discarded unless it is nil.
+	TopicDiscarded func(topic string)
+}
+
+var DefaultOptions = Options{
+	EventQueueSize:       1,
+	SubscriberBufferSize: 1,
+	GroupBalancing:       RoundRobin,
+	AckTimeout:           30 * time.Second,
+	MaxDeliveryAttempts:  5,
+	DeadLetterSuffix:     ".dead-letter",
 }
 
 type Broker struct {
-	stopCh  chan struct{}
-	eventCh chan event
+	options      Options
+	stopCh       chan struct{}
+	eventCh      chan event
+	metrics      *brokerMetrics
+	closedTopics sync.Map
+	// admitMutex serializes admitting publishers with Shutdown, so that it
+	// waits for publishers admitted before it started without holding a
+	// lock across their blocking sends.
+	admitMutex   sync.Mutex
+	publishers   sync.WaitGroup
+	shuttingDown atomic.Bool
 }
 
 func NewBroker() *Broker {
-	return &Broker{
+	return NewBrokerWithOptions(DefaultOptions)
+}
+
+func NewBrokerWithOptions(options Options) *Broker {
+	b := &Broker{
+		options: options,
 		stopCh:  make(chan struct{}),
-		eventCh: make(chan event, 1),
+		eventCh: make(chan event, options.EventQueueSize),
 	}
+	b.metrics = newBrokerMetrics(b)
+	return b
 }
 
 func (b *Broker) Start() {
-	subs := map[chan interface{}]struct{}{}
+	state := newBrokerState(b)
+	expiryTicker := time.NewTicker(expirySweepInterval)
+	defer expiryTicker.Stop()
 	for {
 		select {
 		case <-b.stopCh:
 			return
+		case now := <-expiryTicker.C:
+			state.discardExpired(now)
+			state.redeliverUnacknowledged(now)
+			state.discardIdleTopics(now)
 		case event := <-b.eventCh:
 			switch event.eventType {
 			case eventSubscribe:
-				msgCh := event.content.(chan interface{})
-				subs[msgCh] = struct{}{}
+				state.subscribe(event.content.(subscription))
 			case eventUnsubscribe:
-				msgCh := event.content.(chan interface{})
-				if _, ok := subs[msgCh]; ok {
-					delete(subs, msgCh)
-					close(msgCh)
-				}
+				state.unsubscribe(event.content.(chan interface{}))
 			case eventPublish:
-				msg := event.content
-				for msgCh := range subs {
-					msgCh <- msg
+				if request, ok := event.content.(publishRequest); ok {
+					request.replyCh <- state.publish(request.msg, event.created)
+				} else {
+					state.publish(event.content, event.created)
 				}
+			case eventTopics:
+				request := event.content.(topicsRequest)
+				request.replyCh <- state.topicInfos(request, event.created)
+			case eventTopicControl:
+				request := event.content.(topicControlRequest)
+				request.replyCh <- state.controlTopic(request)
+			case eventShutdown:
+				replyCh := event.content.(chan int)
+				replyCh <- state.shutdown()
+			case eventPing:
+				close(event.content.(chan struct{}))
+			case eventAck:
+				request := event.content.(ackRequest)
+				request.replyCh <- state.ack(request)
+			case eventPresence:
+				request := event.content.(presenceRequest)
+				request.replyCh <- state.presence(request)
 			}
 		}
 	}
 }
 
+func messageTopic(msg interface{}) (topic string, ok bool) {
+	if topicMessage, ok := msg.(TopicMessage); ok {
+		return topicMessage.Topic(), true
+	}
+	return "", false
+}
+
 func (b *Broker) Stop() {
 	close(b.stopCh)
 }
 
+// Subscribe returns channel receiving messages of all topics.
 func (b *Broker) Subscribe() chan interface{} {
-	msgCh := make(chan interface{}, 1)
+	return b.subscribe(subscription{allTopics: true})
+}
+
+// SubscribeTopic returns channel receiving messages of the topic only.
+func (b *Broker) SubscribeTopic(topic string) chan interface{} {
+	return b.subscribe(subscription{topic: topic})
+}
+
+func (b *Broker) subscribe(sub subscription) chan interface{} {
+	sub.msgCh = make(chan interface{}, b.options.SubscriberBufferSize)
 	b.eventCh <- event{
 		eventType: eventSubscribe,
-		content:   msgCh,
+		content:   sub,
 	}
-	return msgCh
+	return sub.msgCh
 }
 
 func (b *Broker) Unsubscribe(msgCh chan interface{}) {
@@ -81,9 +215,53 @@ func (b *Broker) Unsubscribe(msgCh chan interface{}) {
 	}
 }
 
-func (b *Broker) Publish(msg interface{}) {
+// publishRequest is a message published by PublishCounted waiting for the
+// number of subscribers it was delivered to.
+type publishRequest struct {
+	msg     interface{}
+	replyCh chan int
+}
+
+// Publish returns false without publishing the message if the broker is
+// shutting down.
+func (b *Broker) Publish(msg interface{}) bool {
+	return b.publish(msg, msg)
+}
+
+// PublishCounted publishes the message like Publish and waits until it is
+// delivered returning the number of subscribers it was delivered to.
+func (b *Broker) PublishCounted(msg interface{}) (subscribers int, ok bool) {
+	replyCh := make(chan int, 1)
+	if !b.publish(msg, publishRequest{msg: msg, replyCh: replyCh}) {
+		return 0, false
+	}
+	return <-replyCh, true
+}
+
+// admit counts the caller as publisher of an event Shutdown waits for
+// unless the broker is shutting down. Admitted callers must call
+// publishers.Done once the event is sent.
+func (b *Broker) admit() bool {
+	b.admitMutex.Lock()
+	defer b.admitMutex.Unlock()
+	if b.shuttingDown.Load() {
+		return false
+	}
+	b.publishers.Add(1)
+	return true
+}
+
+func (b *Broker) publish(msg interface{}, content interface{}) bool {
+	if !b.admit() {
+		return false
+	}
+	defer b.publishers.Done()
+	topic, _ := messageTopic(msg)
+	b.metrics.published.Inc(topic)
 	b.eventCh <- event{
 		eventType: eventPublish,
-		content:   msg,
+		content:   content,
+		created:   time.Now(),
 	}
+	return true
 }
@@ -1,7 +1,9 @@
 package chanbroker
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBroker_Publish(t *testing.T) {
@@ -73,3 +75,555 @@ func TestConcurrentPublish_Unsubscribe(t *testing.T) {
 	b.Unsubscribe(msgCh) // Allow unsubscribe already unsubscribed channel
 	b.Publish("dummy message")
 }
+
+type testTopicMessage struct {
+	topic string
+}
+
+func (m testTopicMessage) Topic() string {
+	return m.topic
+}
+
+func TestBroker_SubscribeTopic(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	topicCh := b.SubscribeTopic("topic")
+	allCh := b.Subscribe()
+	b.Publish(testTopicMessage{"other"})
+	b.Publish(testTopicMessage{"topic"})
+	if msg := <-allCh; msg != (testTopicMessage{"other"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if msg := <-topicCh; msg != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if msg := <-allCh; msg != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if b.metrics.subscribers.Value("topic") != 1 {
+		t.Fatalf("Unexpected subscriber count %v", b.metrics.subscribers.Value("topic"))
+	}
+	b.Unsubscribe(topicCh)
+	b.Unsubscribe(allCh)
+	if b.metrics.subscribers.Value("topic") != 0 {
+		t.Fatalf("Unexpected subscriber count %v", b.metrics.subscribers.Value("topic"))
+	}
+	if b.metrics.published.Value("topic") != 1 || b.metrics.delivered.Value("topic") != 2 {
+		t.Fatalf("Unexpected published %v and delivered %v counts",
+			b.metrics.published.Value("topic"), b.metrics.delivered.Value("topic"))
+	}
+	if b.metrics.publishDuration.Count("other") != 1 {
+		t.Fatalf("Unexpected publish duration count %d", b.metrics.publishDuration.Count("other"))
+	}
+}
+
+func TestBroker_PublishCounted(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	topicCh := b.SubscribeTopic("topic")
+	allCh := b.Subscribe()
+	if subscribers, ok := b.PublishCounted(testTopicMessage{"topic"}); !ok || subscribers != 2 {
+		t.Fatalf("PublishCounted returned %d, %v", subscribers, ok)
+	}
+	<-topicCh
+	<-allCh
+	if subscribers, ok := b.PublishCounted(testTopicMessage{"other"}); !ok || subscribers != 1 {
+		t.Fatalf("PublishCounted returned %d, %v", subscribers, ok)
+	}
+	<-allCh
+	b.Unsubscribe(topicCh)
+	b.Unsubscribe(allCh)
+	b.CloseTopic("topic")
+	if subscribers, ok := b.PublishCounted(testTopicMessage{"topic"}); !ok || subscribers != 0 {
+		t.Fatalf("PublishCounted to closed topic returned %d, %v", subscribers, ok)
+	}
+}
+
+func TestBroker_SubscribeGroup(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	topicCh := b.SubscribeTopic("topic")
+	firstCh := b.SubscribeGroup("topic", "workers")
+	secondCh := b.SubscribeGroup("topic", "workers")
+	otherCh := b.SubscribeGroup("topic", "others")
+	for i := 0; i < 4; i++ {
+		if subscribers, _ := b.PublishCounted(testRetainedMessage{"topic", i}); subscribers != 3 {
+			t.Fatalf("Message delivered to %d subscribers but expected 3", subscribers)
+		}
+		<-topicCh
+		<-otherCh
+		workerCh := firstCh
+		if i%2 == 1 {
+			workerCh = secondCh
+		}
+		if msg := <-workerCh; msg != (testRetainedMessage{"topic", i}) {
+			t.Fatalf("Unexpected message %v", msg)
+		}
+	}
+	if info, _ := b.Topic("topic"); info.Subscribers != 4 || info.Groups["workers"] != 2 || info.Groups["others"] != 1 {
+		t.Fatalf("Unexpected topic info %v", info)
+	}
+	b.Unsubscribe(firstCh)
+	b.Publish(testTopicMessage{"topic"})
+	<-topicCh
+	<-otherCh
+	if msg := <-secondCh; msg != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(secondCh)
+	b.Unsubscribe(otherCh)
+	b.Unsubscribe(topicCh)
+	if info, _ := b.Topic("topic"); len(info.Groups) != 0 {
+		t.Fatalf("Unexpected groups %v", info.Groups)
+	}
+}
+
+func TestBroker_SubscribeGroupLeastLoaded(t *testing.T) {
+	b := NewBrokerWithOptions(Options{EventQueueSize: 1, SubscriberBufferSize: 2, GroupBalancing: LeastLoaded})
+	go b.Start()
+	defer b.Stop()
+	busyCh := b.SubscribeGroup("topic", "workers")
+	idleCh := b.SubscribeGroup("topic", "workers")
+	b.PublishCounted(testRetainedMessage{"topic", 1})
+	b.PublishCounted(testRetainedMessage{"topic", 2})
+	// The first message went to busy member which did not receive it yet,
+	// so the second one went to idle member and so does the third one.
+	if msg := <-idleCh; msg != (testRetainedMessage{"topic", 2}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.PublishCounted(testRetainedMessage{"topic", 3})
+	if msg := <-idleCh; msg != (testRetainedMessage{"topic", 3}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if msg := <-busyCh; msg != (testRetainedMessage{"topic", 1}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(busyCh)
+	b.Unsubscribe(idleCh)
+}
+
+func TestBroker_SubscribeGroupAck(t *testing.T) {
+	savedExpirySweepInterval := expirySweepInterval
+	expirySweepInterval = 10 * time.Millisecond
+	defer func() { expirySweepInterval = savedExpirySweepInterval }()
+	b := NewBrokerWithOptions(Options{EventQueueSize: 1, SubscriberBufferSize: 1, GroupBalancing: RoundRobin,
+		AckTimeout: 50 * time.Millisecond, MaxDeliveryAttempts: 2, DeadLetterSuffix: ".dead-letter"})
+	go b.Start()
+	defer b.Stop()
+	firstCh := b.SubscribeGroupAck("topic", "workers")
+	secondCh := b.SubscribeGroupAck("topic", "workers")
+	deadLetterCh := b.SubscribeTopic("topic.dead-letter")
+
+	b.Publish(testTopicMessage{"topic"})
+	delivery := (<-firstCh).(Delivery)
+	if delivery.Attempt != 1 || delivery.Message != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected delivery %v", delivery)
+	}
+	if !b.Ack("topic", delivery.ID) {
+		t.Fatal("Expected delivery to be acknowledged")
+	}
+	if b.Ack("topic", delivery.ID) {
+		t.Fatal("Expected acknowledged delivery not to be in flight")
+	}
+
+	b.Publish(testTopicMessage{"topic"})
+	delivery = (<-secondCh).(Delivery)
+	redelivery := (<-firstCh).(Delivery)
+	if redelivery.ID == delivery.ID || !strings.HasPrefix(redelivery.ID, "2-") || redelivery.Attempt != 2 {
+		t.Fatalf("Unexpected redelivery %v of %v", redelivery, delivery)
+	}
+	if b.Ack("topic", delivery.ID) {
+		t.Fatal("Expected previous delivery not to be acknowledged")
+	}
+	msg := (<-deadLetterCh).(DeadLetter)
+	if msg.Topic() != "topic.dead-letter" || msg.Attempts != 2 || msg.Message != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected dead letter %v", msg)
+	}
+	if b.Ack("topic", redelivery.ID) {
+		t.Fatal("Expected dead letter not to be in flight")
+	}
+	b.Unsubscribe(firstCh)
+	b.Unsubscribe(secondCh)
+	b.Unsubscribe(deadLetterCh)
+}
+
+func TestBroker_SubscribeGroupAckWithoutMembers(t *testing.T) {
+	savedExpirySweepInterval := expirySweepInterval
+	expirySweepInterval = 10 * time.Millisecond
+	defer func() { expirySweepInterval = savedExpirySweepInterval }()
+	for _, maxDeliveryAttempts := range []int{0, 3} {
+		b := NewBrokerWithOptions(Options{EventQueueSize: 1, SubscriberBufferSize: 1,
+			AckTimeout: 50 * time.Millisecond, MaxDeliveryAttempts: maxDeliveryAttempts,
+			DeadLetterSuffix: ".dead-letter"})
+		go b.Start()
+		firstCh := b.SubscribeGroupAck("topic", "workers")
+		secondCh := b.SubscribeGroupAck("topic", "workers")
+		deadLetterCh := b.SubscribeTopic("topic.dead-letter")
+
+		b.Publish(testTopicMessage{"topic"})
+		b.Unsubscribe(firstCh)
+		b.Unsubscribe(secondCh)
+		select {
+		case msg := <-deadLetterCh:
+			if deadLetter := msg.(DeadLetter); deadLetter.Message != (testTopicMessage{"topic"}) {
+				t.Errorf("Unexpected dead letter %v", deadLetter)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected message of group without members to be dead letter with %d attempts",
+				maxDeliveryAttempts)
+		}
+		b.Unsubscribe(deadLetterCh)
+		b.Stop()
+	}
+}
+
+func TestBroker_Presence(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	watcherCh := b.SubscribeTopic("topic")
+	workerCh := b.SubscribeGroup("topic", "workers")
+	aliceCh := b.SubscribeWith("topic", SubscribeOptions{ClientID: "alice"})
+	if msg := <-watcherCh; msg != (Presence{PresenceTopic: "topic", ClientID: "alice", Joined: true}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	bobCh := b.SubscribeWith("topic", SubscribeOptions{ClientID: "bob"})
+	<-watcherCh
+	if msg := <-aliceCh; msg != (Presence{PresenceTopic: "topic", ClientID: "bob", Joined: true}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	secondAliceCh := b.SubscribeWith("topic", SubscribeOptions{ClientID: "alice"})
+	if clients := b.Presence("topic"); len(clients) != 2 || clients[0] != "alice" || clients[1] != "bob" {
+		t.Fatalf("Unexpected presence %v", clients)
+	}
+	b.Unsubscribe(aliceCh)
+	b.Unsubscribe(bobCh)
+	if msg := <-watcherCh; msg != (Presence{PresenceTopic: "topic", ClientID: "bob", Joined: false}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if msg := <-secondAliceCh; msg != (Presence{PresenceTopic: "topic", ClientID: "bob", Joined: false}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(secondAliceCh)
+	<-watcherCh
+	if clients := b.Presence("topic"); len(clients) != 0 {
+		t.Fatalf("Unexpected presence %v", clients)
+	}
+	select {
+	case msg := <-workerCh:
+		t.Fatalf("Unexpected group member message %v", msg)
+	default:
+	}
+	b.Unsubscribe(workerCh)
+	b.Unsubscribe(watcherCh)
+}
+
+func TestBroker_Hooks(t *testing.T) {
+	var hooks []string
+	options := DefaultOptions
+	options.FirstSubscriber = func(topic string) { hooks = append(hooks, "first "+topic) }
+	options.LastUnsubscribed = func(topic string) { hooks = append(hooks, "last "+topic) }
+	options.Published = func(msg interface{}) { hooks = append(hooks, "published "+msg.(testTopicMessage).topic) }
+	b := NewBrokerWithOptions(options)
+	go b.Start()
+	defer b.Stop()
+	firstCh := b.SubscribeTopic("topic")
+	secondCh := b.SubscribeTopic("topic")
+	allCh := b.Subscribe()
+	b.Unsubscribe(firstCh)
+	b.Unsubscribe(secondCh)
+	b.Unsubscribe(allCh)
+	b.CloseTopic("closed")
+	b.Publish(testTopicMessage{"closed"})
+	b.Publish(testTopicMessage{"topic"})
+	b.Ping(time.Second)
+	if len(hooks) != 3 || hooks[0] != "first topic" || hooks[1] != "last topic" || hooks[2] != "published topic" {
+		t.Fatalf("Unexpected hooks %v", hooks)
+	}
+}
+
+func TestBroker_Topics(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	if topics := b.Topics(); len(topics) != 0 {
+		t.Fatalf("Unexpected topics %v", topics)
+	}
+	msgCh := b.SubscribeTopic("b-topic")
+	b.Publish(testTopicMessage{"b-topic"})
+	<-msgCh
+	b.Publish(testTopicMessage{"a-topic"})
+
+	topics := b.Topics()
+	if len(topics) != 2 || topics[0].Topic != "a-topic" || topics[1].Topic != "b-topic" {
+		t.Fatalf("Unexpected topics %v", topics)
+	}
+	info, ok := b.Topic("b-topic")
+	if !ok {
+		t.Fatal("Topic b-topic not found")
+	}
+	if info.Subscribers != 1 || info.Messages != 1 || info.LastMessage.IsZero() ||
+		info.MessageRate != 1.0/messageRateWindow {
+		t.Fatalf("Unexpected topic info %v", info)
+	}
+	if _, ok := b.Topic("unknown"); ok {
+		t.Fatal("Unexpected unknown topic")
+	}
+	b.Unsubscribe(msgCh)
+}
+
+func TestBroker_DiscardIdleTopics(t *testing.T) {
+	savedExpirySweepInterval, savedIdleTopicTimeout := expirySweepInterval, idleTopicTimeout
+	expirySweepInterval, idleTopicTimeout = 10*time.Millisecond, 50*time.Millisecond
+	defer func() { expirySweepInterval, idleTopicTimeout = savedExpirySweepInterval, savedIdleTopicTimeout }()
+	discarded := make(chan string, 1)
+	options := DefaultOptions
+	options.TopicDiscarded = func(topic string) { discarded <- topic }
+	b := NewBrokerWithOptions(options)
+	go b.Start()
+	defer b.Stop()
+	msgCh := b.SubscribeTopic("subscribed")
+	b.Publish(testTopicMessage{"subscribed"})
+	<-msgCh
+	b.CloseTopic("closed")
+	b.Publish(testTopicMessage{"idle"})
+	if b.metrics.published.Value("idle") != 1 {
+		t.Fatal("Expected published message to be counted")
+	}
+	select {
+	case topic := <-discarded:
+		if topic != "idle" {
+			t.Fatalf("Unexpected discarded topic %q", topic)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected idle topic to be discarded")
+	}
+	if _, ok := b.Topic("idle"); ok {
+		t.Fatal("Unexpected discarded topic")
+	}
+	if b.metrics.published.Value("idle") != 0 {
+		t.Fatal("Expected series of discarded topic to be deleted")
+	}
+	if info, ok := b.Topic("subscribed"); !ok || info.Subscribers != 1 {
+		t.Fatalf("Unexpected subscribed topic %v", info)
+	}
+	if info, ok := b.Topic("closed"); !ok || !info.Closed {
+		t.Fatalf("Unexpected closed topic %v", info)
+	}
+	b.Unsubscribe(msgCh)
+}
+
+func TestBroker_CloseTopic(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	msgCh := b.SubscribeTopic("topic")
+	if count := b.KickSubscribers("topic"); count != 1 {
+		t.Fatalf("Unexpected kicked subscriber count %d", count)
+	}
+	if msg := <-msgCh; msg != (Closed{Reason: ClosedReasonKicked}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if count := b.CloseTopic("topic"); count != 1 {
+		t.Fatalf("Unexpected closed subscriber count %d", count)
+	}
+	if msg := <-msgCh; msg != (Closed{Reason: ClosedReasonTopicClosed}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(msgCh)
+	if !b.TopicClosed("topic") {
+		t.Fatal("Expected topic to be closed")
+	}
+
+	msgCh = b.SubscribeTopic("topic")
+	if msg := <-msgCh; msg != (Closed{Reason: ClosedReasonTopicClosed}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Publish(testTopicMessage{"topic"})
+	b.OpenTopic("topic")
+	if b.TopicClosed("topic") {
+		t.Fatal("Expected topic to be open")
+	}
+	b.Publish(testTopicMessage{"topic"})
+	if msg := <-msgCh; msg != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(msgCh)
+	if info, _ := b.Topic("topic"); info.Messages != 1 {
+		t.Fatalf("Unexpected message count %d", info.Messages)
+	}
+}
+
+func TestBroker_Shutdown(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	msgCh := b.SubscribeTopic("topic")
+	b.Publish(testTopicMessage{"topic"})
+	shutdownDone := make(chan int)
+	go func() {
+		shutdownDone <- b.Shutdown()
+	}()
+	if msg := <-msgCh; msg != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if msg := <-msgCh; msg != (Shutdown{}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if count := <-shutdownDone; count != 1 {
+		t.Fatalf("Unexpected notified subscriber count %d", count)
+	}
+	if b.Publish(testTopicMessage{"topic"}) {
+		t.Fatal("Expected publish to fail after shutdown")
+	}
+	b.Unsubscribe(msgCh)
+	msgCh = b.SubscribeTopic("topic")
+	if msg := <-msgCh; msg != (Shutdown{}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(msgCh)
+}
+
+func TestBroker_ShutdownWaitsForPublishers(t *testing.T) {
+	options := DefaultOptions
+	options.EventQueueSize = 0
+	b := NewBrokerWithOptions(options)
+	defer b.Stop()
+	published := make(chan bool)
+	go func() {
+		published <- b.Publish(testTopicMessage{"topic"})
+	}()
+	for deadline := time.Now().Add(2 * time.Second); b.metrics.published.Value("topic") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected publish to be admitted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	shutdownDone := make(chan int)
+	go func() {
+		shutdownDone <- b.Shutdown()
+	}()
+	for !b.ShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	go b.Start()
+	if !<-published {
+		t.Fatal("Expected publish admitted before shutdown to succeed")
+	}
+	<-shutdownDone
+	if info, ok := b.Topic("topic"); !ok || info.Messages != 1 {
+		t.Fatalf("Unexpected topic info %v", info)
+	}
+}
+
+func TestBroker_Ping(t *testing.T) {
+	b := NewBroker()
+	if b.Ping(10 * time.Millisecond) {
+		t.Fatal("Expected ping to fail before start")
+	}
+	go b.Start()
+	if !b.Ping(time.Second) {
+		t.Fatal("Expected ping to succeed")
+	}
+	b.Stop()
+	if b.Ping(10 * time.Millisecond) {
+		t.Fatal("Expected ping to fail after stop")
+	}
+}
+
+type testRetainedMessage struct {
+	topic string
+	value int
+}
+
+func (m testRetainedMessage) Topic() string {
+	return m.topic
+}
+
+func (m testRetainedMessage) Retained() bool {
+	return true
+}
+
+func TestBroker_Retained(t *testing.T) {
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	b.Publish(testRetainedMessage{"topic", 1})
+	b.Publish(testRetainedMessage{"topic", 2})
+	b.Publish(testTopicMessage{"topic"})
+
+	msgCh := b.SubscribeTopic("topic")
+	b.Publish(testTopicMessage{"topic"})
+	if msg := <-msgCh; msg != (testRetainedMessage{"topic", 2}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	if msg := <-msgCh; msg != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(msgCh)
+	if info, _ := b.Topic("topic"); !info.Retained {
+		t.Fatal("Expected topic to have retained message")
+	}
+
+	if !b.ClearRetained("topic") {
+		t.Fatal("Expected retained message to be cleared")
+	}
+	if b.ClearRetained("topic") {
+		t.Fatal("Expected no retained message to clear")
+	}
+	msgCh = b.SubscribeTopic("topic")
+	b.Publish(testTopicMessage{"topic"})
+	if msg := <-msgCh; msg != (testTopicMessage{"topic"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(msgCh)
+}
+
+type testExpiringMessage struct {
+	topic   string
+	expires time.Time
+}
+
+func (m testExpiringMessage) Topic() string {
+	return m.topic
+}
+
+func (m testExpiringMessage) Retained() bool {
+	return true
+}
+
+func (m testExpiringMessage) Expires() time.Time {
+	return m.expires
+}
+
+func TestBroker_Expiry(t *testing.T) {
+	savedExpirySweepInterval := expirySweepInterval
+	expirySweepInterval = 10 * time.Millisecond
+	defer func() { expirySweepInterval = savedExpirySweepInterval }()
+	b := NewBroker()
+	go b.Start()
+	defer b.Stop()
+	b.Publish(testExpiringMessage{"expired", time.Now().Add(-time.Second)})
+	msgCh := b.SubscribeTopic("expired")
+	b.Publish(testTopicMessage{"expired"})
+	if msg := <-msgCh; msg != (testTopicMessage{"expired"}) {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+	b.Unsubscribe(msgCh)
+
+	b.Publish(testExpiringMessage{"expiring", time.Now().Add(100 * time.Millisecond)})
+	if info, _ := b.Topic("expiring"); !info.Retained {
+		t.Fatal("Expected topic to have retained message")
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if info, _ := b.Topic("expiring"); !info.Retained {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected expired retained message to be discarded")
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package chanbroker
+
+const (
+	ClosedReasonKicked      = "kicked"
+	ClosedReasonTopicClosed = "closed"
+)
+
+// Closed is delivered to topic subscribers which should stop receiving
+// messages. Subscribers are expected to unsubscribe after receiving it.
+type Closed struct {
+	Reason string
+}
+
+type topicAction int
+
+const (
+	topicActionKick topicAction = iota
+	topicActionClose
+	topicActionOpen
+	topicActionClearRetained
+)
+
+type topicControlRequest struct {
+	topic   string
+	action  topicAction
+	replyCh chan int
+}
+
+func (s *brokerState) controlTopic(request topicControlRequest) int {
+	switch request.action {
+	case topicActionKick:
+		return s.closeSubscriptions(request.topic, ClosedReasonKicked)
+	case topicActionClose:
+		s.topic(request.topic).closed = true
+		s.broker.closedTopics.Store(request.topic, struct{}{})
+		return s.closeSubscriptions(request.topic, ClosedReasonTopicClosed)
+	case topicActionOpen:
+		if topic, ok := s.topics[request.topic]; ok {
+			topic.closed = false
+		}
+		s.broker.closedTopics.Delete(request.topic)
+	case topicActionClearRetained:
+		if topic, ok := s.topics[request.topic]; ok && topic.retained != nil {
+			topic.retained = nil
+			return 1
+		}
+	}
+	return 0
+}
+
+func (s *brokerState) closeSubscriptions(topic string, reason string) int {
+	count := 0
+	for msgCh, sub := range s.subs {
+		if sub.allTopics || sub.topic != topic {
+			continue
+		}
+		msgCh <- Closed{Reason: reason}
+		count++
+	}
+	return count
+}
+
+// KickSubscribers delivers Closed to all subscribers of the topic and
+// returns their count.
+func (b *Broker) KickSubscribers(topic string) int {
+	return b.requestTopicControl(topic, topicActionKick)
+}
+
+// CloseTopic kicks all subscribers of the topic and blocks it. Messages
+// published to the blocked topic are discarded and new subscribers receive
+// Closed immediately. Returns count of kicked subscribers.
+func (b *Broker) CloseTopic(topic string) int {
+	return b.requestTopicControl(topic, topicActionClose)
+}
+
+// TopicClosed reports whether the topic is blocked by CloseTopic.
+func (b *Broker) TopicClosed(topic string) bool {
+	_, closed := b.closedTopics.Load(topic)
+	return closed
+}
+
+// OpenTopic unblocks the topic closed by CloseTopic.
+func (b *Broker) OpenTopic(topic string) {
+	b.requestTopicControl(topic, topicActionOpen)
+}
+
+func (b *Broker) requestTopicControl(topic string, action topicAction) int {
+	request := topicControlRequest{topic: topic, action: action, replyCh: make(chan int, 1)}
+	b.eventCh <- event{
+		eventType: eventTopicControl,
+		content:   request,
+	}
+	return <-request.replyCh
+}
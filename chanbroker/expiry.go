@@ -0,0 +1,35 @@
+package chanbroker
+
+import (
+	"time"
+)
+
+// expirySweepInterval is the interval of discarding expired retained
+// messages and idle topics.
+var expirySweepInterval = time.Second
+
+// ExpiringMessage is implemented by messages which expire. Expired retained
+// messages are not delivered to new subscribers and are discarded. Zero
+// expiry time means the message does not expire.
+type ExpiringMessage interface {
+	Expires() time.Time
+}
+
+// MessageExpired reports whether the message is expired at the time.
+func MessageExpired(msg interface{}, now time.Time) bool {
+	expiringMessage, ok := msg.(ExpiringMessage)
+	if !ok {
+		return false
+	}
+	expires := expiringMessage.Expires()
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+// discardExpired discards expired retained messages.
+func (s *brokerState) discardExpired(now time.Time) {
+	for _, topic := range s.topics {
+		if topic.retained != nil && MessageExpired(topic.retained, now) {
+			topic.retained = nil
+		}
+	}
+}
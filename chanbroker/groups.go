@@ -0,0 +1,79 @@
+package chanbroker
+
+// Balancing selects the member of a consumer group receiving a message.
+type Balancing int
+
+const (
+	// RoundRobin delivers messages to group members in turn.
+	RoundRobin Balancing = iota
+	// LeastLoaded delivers a message to the member with the fewest
+	// messages delivered but not received yet. Equally loaded members
+	// receive messages in turn.
+	LeastLoaded
+)
+
+// consumerGroup is the members of a topic consumer group in order of
+// subscribing.
+type consumerGroup struct {
+	members []chan interface{}
+	next    int
+}
+
+// SubscribeGroup returns channel receiving messages of the topic shared
+// with other subscribers of the group. Every message is delivered to a
+// single member of every group of the topic.
+func (b *Broker) SubscribeGroup(topic string, group string) chan interface{} {
+	return b.subscribe(subscription{topic: topic, group: group})
+}
+
+func (g *consumerGroup) remove(msgCh chan interface{}) {
+	for i, member := range g.members {
+		if member == msgCh {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			if g.next > i {
+				g.next--
+			}
+			return
+		}
+	}
+}
+
+// pick returns the member to deliver the next message to.
+func (g *consumerGroup) pick(balancing Balancing) chan interface{} {
+	if g.next >= len(g.members) {
+		g.next = 0
+	}
+	picked := g.next
+	if balancing == LeastLoaded {
+		for i := 1; i < len(g.members); i++ {
+			member := (g.next + i) % len(g.members)
+			if len(g.members[member]) < len(g.members[picked]) {
+				picked = member
+			}
+		}
+	}
+	g.next = picked + 1
+	return g.members[picked]
+}
+
+// pickExcept returns the member to deliver the next message to other than
+// the excluded one unless it is the only member.
+func (g *consumerGroup) pickExcept(balancing Balancing, excluded chan interface{}) chan interface{} {
+	member := g.pick(balancing)
+	if member == excluded && len(g.members) > 1 {
+		member = g.pick(balancing)
+	}
+	return member
+}
+
+// groupSizes returns the number of members of every group.
+func (t *topicState) groupSizes() map[string]int {
+	if len(t.groups) == 0 {
+		return nil
+	}
+	sizes := make(map[string]int, len(t.groups))
+	for name, group := range t.groups {
+		sizes[name] = len(group.members)
+	}
+	return sizes
+}
@@ -0,0 +1,59 @@
+package chanbroker
+
+import (
+	"github.com/vaidasn/infocenter/metrics"
+)
+
+type brokerMetrics struct {
+	published       *metrics.CounterVec
+	publishDuration *metrics.HistogramVec
+	delivered       *metrics.CounterVec
+	redelivered     *metrics.CounterVec
+	deadLettered    *metrics.CounterVec
+	subscribers     *metrics.GaugeVec
+	eventQueueDepth *metrics.GaugeFunc
+}
+
+func newBrokerMetrics(b *Broker) *brokerMetrics {
+	return &brokerMetrics{
+		published: metrics.NewCounterVec("infocenter_broker_published_messages_total",
+			"Number of messages published per topic.", "topic"),
+		publishDuration: metrics.NewHistogramVec("infocenter_broker_publish_duration_seconds",
+			"Time from publishing a message until it is handed over to all topic subscribers.",
+			metrics.DefaultBuckets, "topic"),
+		delivered: metrics.NewCounterVec("infocenter_broker_delivered_messages_total",
+			"Number of messages handed over to subscribers per topic.", "topic"),
+		redelivered: metrics.NewCounterVec("infocenter_broker_redelivered_messages_total",
+			"Number of messages delivered again as not acknowledged in time per topic.", "topic"),
+		deadLettered: metrics.NewCounterVec("infocenter_broker_dead_lettered_messages_total",
+			"Number of messages given up after maximum delivery attempts per topic.", "topic"),
+		subscribers: metrics.NewGaugeVec("infocenter_broker_subscribers",
+			"Number of active subscribers per topic.", "topic"),
+		eventQueueDepth: metrics.NewGaugeFunc("infocenter_broker_event_queue_depth",
+			"Number of subscribe, unsubscribe and publish events waiting to be processed.",
+			func() float64 { return float64(len(b.eventCh)) }),
+	}
+}
+
+// deleteTopic deletes series of the topic.
+func (m *brokerMetrics) deleteTopic(topic string) {
+	m.published.DeleteLabelValues(topic)
+	m.publishDuration.DeleteLabelValues(topic)
+	m.delivered.DeleteLabelValues(topic)
+	m.redelivered.DeleteLabelValues(topic)
+	m.deadLettered.DeleteLabelValues(topic)
+	m.subscribers.DeleteLabelValues(topic)
+}
+
+// RegisterMetrics registers broker metrics into the registry.
+func (b *Broker) RegisterMetrics(registry *metrics.Registry) {
+	registry.MustRegister(
+		b.metrics.published,
+		b.metrics.publishDuration,
+		b.metrics.delivered,
+		b.metrics.redelivered,
+		b.metrics.deadLettered,
+		b.metrics.subscribers,
+		b.metrics.eventQueueDepth,
+	)
+}
@@ -0,0 +1,24 @@
+package chanbroker
+
+import (
+	"time"
+)
+
+// Ping reports whether the Start goroutine processes events within the
+// timeout.
+func (b *Broker) Ping(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	replyCh := make(chan struct{}, 1)
+	select {
+	case b.eventCh <- event{eventType: eventPing, content: replyCh}:
+	case <-timer.C:
+		return false
+	}
+	select {
+	case <-replyCh:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,96 @@
+package chanbroker
+
+import (
+	"sort"
+)
+
+// Presence is delivered to topic subscribers outside consumer groups when
+// a client joins the topic by its first subscription or leaves it by
+// unsubscribing the last one. Subscriptions of the client itself do not
+// receive it.
+type Presence struct {
+	PresenceTopic string
+	ClientID      string
+	Joined        bool
+}
+
+func (m Presence) Topic() string {
+	return m.PresenceTopic
+}
+
+type presenceRequest struct {
+	topic   string
+	replyCh chan []string
+}
+
+// SubscribeOptions select how SubscribeWith subscribes to a topic.
+type SubscribeOptions struct {
+	// Group is the consumer group name or empty.
+	Group string
+	// Ack makes the group member acknowledge messages.
+	Ack bool
+	// ClientID makes the subscriber present in the topic unless it is
+	// empty.
+	ClientID string
+}
+
+// SubscribeWith returns channel of the topic subscription with the options.
+func (b *Broker) SubscribeWith(topic string, options SubscribeOptions) chan interface{} {
+	return b.subscribe(subscription{topic: topic, group: options.Group, ack: options.Ack && options.Group != "",
+		clientID: options.ClientID})
+}
+
+// Presence returns ids of clients present in the topic in order.
+func (b *Broker) Presence(topic string) []string {
+	replyCh := make(chan []string, 1)
+	b.eventCh <- event{
+		eventType: eventPresence,
+		content:   presenceRequest{topic: topic, replyCh: replyCh},
+	}
+	return <-replyCh
+}
+
+// join counts the subscription of the client and notifies other
+// subscribers if it is the first one.
+func (s *brokerState) join(topic *topicState, sub subscription) {
+	if topic.clients == nil {
+		topic.clients = map[string]int{}
+	}
+	topic.clients[sub.clientID]++
+	if topic.clients[sub.clientID] == 1 {
+		s.notifyPresence(topic, Presence{PresenceTopic: sub.topic, ClientID: sub.clientID, Joined: true})
+	}
+}
+
+// leave uncounts the subscription of the client and notifies other
+// subscribers if it was the last one.
+func (s *brokerState) leave(topic *topicState, sub subscription) {
+	topic.clients[sub.clientID]--
+	if topic.clients[sub.clientID] == 0 {
+		delete(topic.clients, sub.clientID)
+		s.notifyPresence(topic, Presence{PresenceTopic: sub.topic, ClientID: sub.clientID, Joined: false})
+	}
+}
+
+func (s *brokerState) notifyPresence(topic *topicState, msg Presence) {
+	if s.shuttingDown || topic.closed {
+		return
+	}
+	for msgCh, sub := range s.subs {
+		if sub.allTopics || sub.topic != msg.PresenceTopic || sub.group != "" || sub.clientID == msg.ClientID {
+			continue
+		}
+		msgCh <- msg
+	}
+}
+
+func (s *brokerState) presence(request presenceRequest) []string {
+	clients := []string{}
+	if topic, ok := s.topics[request.topic]; ok {
+		for clientID := range topic.clients {
+			clients = append(clients, clientID)
+		}
+	}
+	sort.Strings(clients)
+	return clients
+}
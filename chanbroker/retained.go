@@ -0,0 +1,21 @@
+package chanbroker
+
+// RetainedMessage is implemented by topic messages which may be retained.
+// The last retained message of a topic is delivered to every new subscriber
+// of the topic before messages published later. Subscribers of all topics
+// do not receive retained messages.
+type RetainedMessage interface {
+	TopicMessage
+	Retained() bool
+}
+
+func messageRetained(msg interface{}) bool {
+	retainedMessage, ok := msg.(RetainedMessage)
+	return ok && retainedMessage.Retained()
+}
+
+// ClearRetained discards the retained message of the topic. Returns false
+// if the topic has no retained message.
+func (b *Broker) ClearRetained(topic string) bool {
+	return b.requestTopicControl(topic, topicActionClearRetained) != 0
+}
@@ -0,0 +1,40 @@
+package chanbroker
+
+// Shutdown is delivered to all subscribers when the broker is shutting
+// down. Subscribers are expected to unsubscribe after receiving it.
+type Shutdown struct{}
+
+func (s *brokerState) shutdown() int {
+	if s.shuttingDown {
+		return 0
+	}
+	s.shuttingDown = true
+	for msgCh := range s.subs {
+		msgCh <- Shutdown{}
+	}
+	return len(s.subs)
+}
+
+// Shutdown stops accepting published messages, waits until messages
+// published before are delivered and then delivers Shutdown to all current
+// and future subscribers. Returns count of notified subscribers.
+//
+// The broker keeps processing subscribe and unsubscribe events until Stop
+// is invoked.
+func (b *Broker) Shutdown() int {
+	b.admitMutex.Lock()
+	b.shuttingDown.Store(true)
+	b.admitMutex.Unlock()
+	b.publishers.Wait()
+	replyCh := make(chan int, 1)
+	b.eventCh <- event{
+		eventType: eventShutdown,
+		content:   replyCh,
+	}
+	return <-replyCh
+}
+
+// ShuttingDown reports whether Shutdown was invoked.
+func (b *Broker) ShuttingDown() bool {
+	return b.shuttingDown.Load()
+}
@@ -0,0 +1,135 @@
+package chanbroker
+
+import (
+	"time"
+)
+
+// brokerState is owned by the Start goroutine and must not be accessed
+// from any other goroutine.
+type brokerState struct {
+	broker       *Broker
+	subs         map[chan interface{}]subscription
+	topics       map[string]*topicState
+	shuttingDown bool
+	// inFlight are messages delivered to group members acknowledging them
+	// by delivery id.
+	inFlight map[string]*inFlight
+}
+
+func newBrokerState(b *Broker) *brokerState {
+	return &brokerState{
+		broker:   b,
+		subs:     map[chan interface{}]subscription{},
+		topics:   map[string]*topicState{},
+		inFlight: map[string]*inFlight{},
+	}
+}
+
+func (s *brokerState) topic(topic string) *topicState {
+	state, ok := s.topics[topic]
+	if !ok {
+		state = &topicState{}
+		s.topics[topic] = state
+	}
+	return state
+}
+
+func (s *brokerState) subscribe(sub subscription) {
+	s.subs[sub.msgCh] = sub
+	var topic *topicState
+	if !sub.allTopics {
+		topic = s.topic(sub.topic)
+		topic.subscribers++
+		s.broker.metrics.subscribers.Add(1, sub.topic)
+		if topic.subscribers == 1 && s.broker.options.FirstSubscriber != nil {
+			s.broker.options.FirstSubscriber(sub.topic)
+		}
+		if sub.group != "" {
+			if topic.groups == nil {
+				topic.groups = map[string]*consumerGroup{}
+			}
+			group, ok := topic.groups[sub.group]
+			if !ok {
+				group = &consumerGroup{}
+				topic.groups[sub.group] = group
+			}
+			group.members = append(group.members, sub.msgCh)
+		}
+		if sub.clientID != "" {
+			s.join(topic, sub)
+		}
+	}
+	if s.shuttingDown {
+		sub.msgCh <- Shutdown{}
+	} else if topic != nil && topic.closed {
+		sub.msgCh <- Closed{Reason: ClosedReasonTopicClosed}
+	} else if topic != nil && topic.retained != nil {
+		if MessageExpired(topic.retained, time.Now()) {
+			topic.retained = nil
+		} else {
+			sub.msgCh <- topic.retained
+		}
+	}
+}
+
+func (s *brokerState) unsubscribe(msgCh chan interface{}) {
+	sub, ok := s.subs[msgCh]
+	if !ok {
+		return
+	}
+	delete(s.subs, msgCh)
+	if !sub.allTopics {
+		topic := s.topic(sub.topic)
+		topic.subscribers--
+		s.broker.metrics.subscribers.Add(-1, sub.topic)
+		if topic.subscribers == 0 && s.broker.options.LastUnsubscribed != nil {
+			s.broker.options.LastUnsubscribed(sub.topic)
+		}
+		if group, ok := topic.groups[sub.group]; ok {
+			group.remove(msgCh)
+			if len(group.members) == 0 {
+				delete(topic.groups, sub.group)
+			}
+		}
+		if sub.ack {
+			s.releaseInFlight(msgCh)
+		}
+		if sub.clientID != "" {
+			s.leave(topic, sub)
+		}
+	}
+	close(msgCh)
+}
+
+// publish returns the number of subscribers the message was delivered to.
+func (s *brokerState) publish(msg interface{}, created time.Time) int {
+	topic, hasTopic := messageTopic(msg)
+	if hasTopic && s.topic(topic).closed {
+		return 0
+	}
+	if s.broker.options.Published != nil {
+		s.broker.options.Published(msg)
+	}
+	delivered := 0
+	for msgCh, sub := range s.subs {
+		if hasTopic && !sub.allTopics && sub.topic != topic || sub.group != "" {
+			continue
+		}
+		msgCh <- msg
+		s.broker.metrics.delivered.Inc(topic)
+		delivered++
+	}
+	if hasTopic {
+		for name, group := range s.topic(topic).groups {
+			s.deliverToGroup(topic, name, group, msg)
+			s.broker.metrics.delivered.Inc(topic)
+			delivered++
+		}
+		s.topic(topic).countMessage(created)
+		if messageRetained(msg) {
+			s.topic(topic).retained = msg
+		}
+	}
+	s.broker.metrics.publishDuration.Observe(time.Since(created).Seconds(), topic)
+	return delivered
+}
@@ -0,0 +1,152 @@
+package chanbroker
+
+import (
+	"sort"
+	"time"
+)
+
+// messageRateWindow is the number of seconds message rate is averaged over.
+const messageRateWindow = 60
+
+// TopicInfo describes a topic which has subscribers, a retained message,
+// messages in flight, is closed or received messages within
+// messageRateWindow. State of other topics is discarded.
+type TopicInfo struct {
+	Topic       string
+	Subscribers int
+	Messages    uint64
+	MessageRate float64
+	LastMessage time.Time
+	Closed      bool
+	Retained    bool
+	// Groups maps consumer group names to their member counts.
+	Groups map[string]int
+}
+
+type topicState struct {
+	closed      bool
+	retained    interface{}
+	subscribers int
+	groups      map[string]*consumerGroup
+	// clients counts subscriptions of present clients by client id.
+	clients       map[string]int
+	messages      uint64
+	lastMessage   time.Time
+	secondCounts  [messageRateWindow]uint64
+	secondNumbers [messageRateWindow]int64
+}
+
+func (t *topicState) countMessage(now time.Time) {
+	second := now.Unix()
+	i := second % messageRateWindow
+	if t.secondNumbers[i] != second {
+		t.secondNumbers[i] = second
+		t.secondCounts[i] = 0
+	}
+	t.secondCounts[i]++
+	t.messages++
+	t.lastMessage = now
+}
+
+// messageRate returns messages per second averaged over messageRateWindow.
+func (t *topicState) messageRate(now time.Time) float64 {
+	second := now.Unix()
+	count := uint64(0)
+	for i, secondNumber := range t.secondNumbers {
+		if second-secondNumber < messageRateWindow {
+			count += t.secondCounts[i]
+		}
+	}
+	return float64(count) / messageRateWindow
+}
+
+// idleTopicTimeout is the time since the last message after which state of
+// otherwise unused topics is discarded. It is a variable so that tests may
+// shorten it.
+var idleTopicTimeout = messageRateWindow * time.Second
+
+// idle reports whether nothing but message counts would be lost if the
+// topic state was discarded.
+func (t *topicState) idle(now time.Time) bool {
+	return t.subscribers == 0 && len(t.groups) == 0 && len(t.clients) == 0 && t.retained == nil && !t.closed &&
+		now.Sub(t.lastMessage) >= idleTopicTimeout
+}
+
+// discardIdleTopics discards state and metrics of idle topics without
+// messages in flight, so that topics used once do not accumulate.
+func (s *brokerState) discardIdleTopics(now time.Time) {
+	inFlightTopics := map[string]struct{}{}
+	for _, f := range s.inFlight {
+		inFlightTopics[f.topic] = struct{}{}
+	}
+	for name, topic := range s.topics {
+		if _, inFlight := inFlightTopics[name]; inFlight || !topic.idle(now) {
+			continue
+		}
+		delete(s.topics, name)
+		s.broker.metrics.deleteTopic(name)
+		if s.broker.options.TopicDiscarded != nil {
+			s.broker.options.TopicDiscarded(name)
+		}
+	}
+}
+
+func (t *topicState) info(topic string, now time.Time) TopicInfo {
+	return TopicInfo{
+		Topic:       topic,
+		Subscribers: t.subscribers,
+		Messages:    t.messages,
+		MessageRate: t.messageRate(now),
+		LastMessage: t.lastMessage,
+		Closed:      t.closed,
+		Retained:    t.retained != nil,
+		Groups:      t.groupSizes(),
+	}
+}
+
+type topicsRequest struct {
+	topic     string
+	allTopics bool
+	replyCh   chan []TopicInfo
+}
+
+func (s *brokerState) topicInfos(request topicsRequest, now time.Time) []TopicInfo {
+	if !request.allTopics {
+		if state, ok := s.topics[request.topic]; ok {
+			return []TopicInfo{state.info(request.topic, now)}
+		}
+		return nil
+	}
+	infos := make([]TopicInfo, 0, len(s.topics))
+	for topic, state := range s.topics {
+		infos = append(infos, state.info(topic, now))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Topic < infos[j].Topic
+	})
+	return infos
+}
+
+// Topics returns information about all known topics ordered by topic name.
+func (b *Broker) Topics() []TopicInfo {
+	return b.requestTopics(topicsRequest{allTopics: true})
+}
+
+// Topic returns information about the topic if it is known.
+func (b *Broker) Topic(topic string) (TopicInfo, bool) {
+	infos := b.requestTopics(topicsRequest{topic: topic})
+	if len(infos) == 0 {
+		return TopicInfo{}, false
+	}
+	return infos[0], true
+}
+
+func (b *Broker) requestTopics(request topicsRequest) []TopicInfo {
+	request.replyCh = make(chan []TopicInfo, 1)
+	b.eventCh <- event{
+		eventType: eventTopics,
+		content:   request,
+		created:   time.Now(),
+	}
+	return <-request.replyCh
+}
@@ -0,0 +1,648 @@
+// Configuration of infocenter application.
+//
+// Configuration is assembled from defaults, configuration file, environment
+// variables and command line options, each one overriding the former.
+// Configuration file is YAML, JSON or TOML depending on its extension.
+// Every setting may be overridden by environment variable named
+// INFOCENTER_<SECTION>_<SETTING>, e.g. INFOCENTER_SERVER_PORT for setting
+// port of section server.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const EnvPrefix = "INFOCENTER_"
+
+type Config struct {
+	Server      ServerConfig      `config:"server"`
+	TLS         TLSConfig         `config:"tls"`
+	CORS        CORSConfig        `config:"cors"`
+	Broker      BrokerConfig      `config:"broker"`
+	Auth        AuthConfig        `config:"auth"`
+	Limits      LimitsConfig      `config:"limits"`
+	Messages    MessagesConfig    `config:"messages"`
+	Persistence PersistenceConfig `config:"persistence"`
+	Topics      TopicsConfig      `config:"topics"`
+	Webhooks    WebhooksConfig    `config:"webhooks"`
+	Inbound     InboundConfig     `config:"inbound"`
+
+	// origins maps dotted setting path to origin of its value.
+	origins map[string]origin
+}
+
+type position struct {
+	line   int
+	column int
+}
+
+// origin is file name with position or environment variable name or
+// command line option name the setting value comes from.
+type origin struct {
+	source string
+	position
+}
+
+type ServerConfig struct {
+	Port uint16 `config:"port"`
+	// Listen is comma separated list of host:port or unix:path addresses
+	// to listen on instead of all interfaces at Port.
+	Listen string `config:"listen"`
+	// AdminListen is comma separated list of addresses serving admin API,
+	// metrics and publishing. Other addresses serve only event streams and
+	// health checks if it is set.
+	AdminListen               string `config:"adminListen"`
+	RoutesPrefix              string `config:"routesPrefix"`
+	EventStreamTimeoutSeconds int    `config:"eventStreamTimeoutSeconds"`
+	ShutdownTimeoutSeconds    int    `config:"shutdownTimeoutSeconds"`
+	ShutdownDelaySeconds      int    `config:"shutdownDelaySeconds"`
+	ShutdownRetrySeconds      int    `config:"shutdownRetrySeconds"`
+	// H2C enables HTTP/2 without TLS for clients using prior knowledge.
+	// HTTP/2 is always negotiated over TLS.
+	H2C bool `config:"h2c"`
+}
+
+// TLSConfig enables HTTPS if CertFile and KeyFile are set. Certificate
+// files are reloaded when they change.
+type TLSConfig struct {
+	CertFile string `config:"certFile"`
+	KeyFile  string `config:"keyFile"`
+	// ClientCAFile holds certificates of authorities issuing client
+	// certificates.
+	ClientCAFile string `config:"clientCaFile"`
+	// ClientAuth is none, request or require. Verified client certificate
+	// common name becomes client identity.
+	ClientAuth string `config:"clientAuth"`
+}
+
+// CORSConfig allows browsers on other origins to subscribe and publish.
+type CORSConfig struct {
+	// AllowedOrigins is comma separated list of origins such as
+	// https://example.com or * for any origin. CORS is disabled if empty.
+	AllowedOrigins string `config:"allowedOrigins"`
+	// AllowCredentials allows requests with cookies or HTTP
+	// authentication. It requires listing origins explicitly.
+	AllowCredentials bool `config:"allowCredentials"`
+	// AllowedHeaders is comma separated list of request headers allowed
+	// in preflight requests.
+	AllowedHeaders string `config:"allowedHeaders"`
+	MaxAgeSeconds  int    `config:"maxAgeSeconds"`
+}
+
+type BrokerConfig struct {
+	EventQueueSize       int `config:"eventQueueSize"`
+	SubscriberBufferSize int `config:"subscriberBufferSize"`
+	// GroupBalancing is roundRobin or leastLoaded. It selects the consumer
+	// group member receiving a message.
+	GroupBalancing string `config:"groupBalancing"`
+	// AckTimeoutSeconds is the time a consumer group member acknowledging
+	// messages has to acknowledge a message before it is redelivered.
+	AckTimeoutSeconds int `config:"ackTimeoutSeconds"`
+	// MaxDeliveryAttempts is the number of deliveries of a message not
+	// acknowledged before it is dead-lettered or 0 if it is not limited.
+	MaxDeliveryAttempts int `config:"maxDeliveryAttempts"`
+	// DeadLetterSuffix makes the topic dead-lettered messages are published
+	// to from their topic. They are discarded if it is empty.
+	DeadLetterSuffix string `config:"deadLetterSuffix"`
+}
+
+type AuthConfig struct {
+	AdminToken string `config:"adminToken"`
+	// AdminIdentities is comma separated list of client certificate
+	// identities allowed to use admin API without admin token.
+	AdminIdentities string `config:"adminIdentities"`
+}
+
+type LimitsConfig struct {
+	// MaxMessageSize is the maximum size of posted message in bytes or 0 if
+	// message size is not limited.
+	MaxMessageSize int64 `config:"maxMessageSize"`
+	// MaxScheduledMessages is the maximum number of messages pending
+	// delayed delivery or 0 if it is not limited.
+	MaxScheduledMessages int `config:"maxScheduledMessages"`
+	// MaxIdempotencyKeys is the maximum number of remembered idempotency
+	// keys or 0 if it is not limited.
+	MaxIdempotencyKeys int `config:"maxIdempotencyKeys"`
+}
+
+type MessagesConfig struct {
+	// DefaultTtlSeconds is the time-to-live of messages published without
+	// one or 0 if they do not expire.
+	DefaultTtlSeconds int `config:"defaultTtlSeconds"`
+	// TopicTtlSeconds is comma separated list of topic=seconds overriding
+	// DefaultTtlSeconds for the topics.
+	TopicTtlSeconds string `config:"topicTtlSeconds"`
+	// IdempotencyWindowSeconds is the time idempotency keys of posted
+	// messages are remembered for.
+	IdempotencyWindowSeconds int `config:"idempotencyWindowSeconds"`
+	// RequestTimeoutSeconds is the default and the maximum time a request
+	// waits for a reply.
+	RequestTimeoutSeconds int `config:"requestTimeoutSeconds"`
+}
+
+type PersistenceConfig struct {
+	// Directory holds persisted state. Nothing is persisted if it is empty.
+	Directory string `config:"directory"`
+}
+
+type TopicsConfig struct {
+	// Policy is autoCreate to allow any topic or declared to allow declared
+	// topics only.
+	Policy string `config:"policy"`
+	// Declared is comma separated list of topics declared at start.
+	Declared string `config:"declared"`
+	// LifecycleTopic is the topic of webhook messages telling that a topic
+	// got its first subscriber or lost its last one. They are not sent if it
+	// is empty.
+	LifecycleTopic string `config:"lifecycleTopic"`
+}
+
+type WebhooksConfig struct {
+	// MaxAttempts limits delivery attempts of a message to a webhook.
+	MaxAttempts int `config:"maxAttempts"`
+	// InitialBackoffSeconds is the delay before the first retry, doubled
+	// before every next one up to MaxBackoffSeconds.
+	InitialBackoffSeconds int `config:"initialBackoffSeconds"`
+	MaxBackoffSeconds     int `config:"maxBackoffSeconds"`
+	// TimeoutSeconds limits a delivery attempt.
+	TimeoutSeconds int `config:"timeoutSeconds"`
+	// QueueSize limits messages waiting for delivery to a webhook.
+	QueueSize int `config:"queueSize"`
+}
+
+type InboundConfig struct {
+	// SourcesFile is JSON file of inbound webhook sources. Inbound
+	// webhooks are not accepted if it is empty.
+	SourcesFile string `config:"sourcesFile"`
+}
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:                      8080,
+			RoutesPrefix:              "/infocenter",
+			EventStreamTimeoutSeconds: 30,
+			ShutdownTimeoutSeconds:    30,
+			ShutdownDelaySeconds:      0,
+			ShutdownRetrySeconds:      5,
+		},
+		TLS: TLSConfig{
+			ClientAuth: "none",
+		},
+		CORS: CORSConfig{
+			AllowedHeaders: "Content-Type, Idempotency-Key, Prefer",
+			MaxAgeSeconds:  600,
+		},
+		Broker: BrokerConfig{
+			EventQueueSize:       1,
+			SubscriberBufferSize: 1,
+			GroupBalancing:       "roundRobin",
+			AckTimeoutSeconds:    30,
+			MaxDeliveryAttempts:  5,
+			DeadLetterSuffix:     ".dead-letter",
+		},
+		Limits: LimitsConfig{
+			MaxScheduledMessages: 10000,
+			MaxIdempotencyKeys:   100000,
+		},
+		Messages: MessagesConfig{
+			IdempotencyWindowSeconds: 86400,
+			RequestTimeoutSeconds:    30,
+		},
+		Topics: TopicsConfig{
+			Policy: "autoCreate",
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:           5,
+			InitialBackoffSeconds: 1,
+			MaxBackoffSeconds:     60,
+			TimeoutSeconds:        10,
+			QueueSize:             1000,
+		},
+	}
+}
+
+// Load returns default configuration overridden by configuration file if
+// fileName is not empty and then by environment variables. Configuration
+// is not validated.
+func Load(fileName string) (Config, error) {
+	config := Default()
+	if fileName != "" {
+		if err := config.decodeFile(fileName); err != nil {
+			return config, err
+		}
+	}
+	for _, env := range os.Environ() {
+		name := env[:strings.Index(env, "=")]
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		if err := config.overrideEnv(name, os.Getenv(name)); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+func (c *Config) overrideEnv(name string, value string) error {
+	var err error
+	found := false
+	c.settings(func(section, setting string, _ reflect.Value) {
+		if EnvName(section, setting) == name {
+			found = true
+			err = c.Override(section+"."+setting, value, name)
+		}
+	})
+	if !found {
+		return &Error{Source: name, Message: "unknown setting"}
+	}
+	return err
+}
+
+// Override sets the setting given as dotted path from string value.
+// Source names the origin of value such as command line option.
+func (c *Config) Override(setting string, value string, source string) error {
+	settingValue, ok := c.setting(setting)
+	if !ok {
+		return &Error{Source: source, Setting: setting, Message: "unknown setting"}
+	}
+	var parsed interface{}
+	switch settingValue.Kind() {
+	case reflect.String:
+		parsed = value
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return &Error{Source: source, Setting: setting, Message: "expected boolean"}
+		}
+		parsed = b
+	default:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return &Error{Source: source, Setting: setting, Message: "expected integer"}
+		}
+		parsed = i
+	}
+	return c.set(setting, parsed, origin{source: source})
+}
+
+func (c *Config) setting(setting string) (value reflect.Value, ok bool) {
+	c.settings(func(section, name string, settingValue reflect.Value) {
+		if section+"."+name == setting {
+			value, ok = settingValue, true
+		}
+	})
+	return
+}
+
+// set assigns value decoded from configuration source to the setting.
+func (c *Config) set(setting string, value interface{}, o origin) error {
+	settingValue, ok := c.setting(setting)
+	if !ok {
+		return &Error{Source: o.source, Line: o.line, Column: o.column, Setting: setting,
+			Message: "unknown setting"}
+	}
+	invalid := func(message string) error {
+		return &Error{Source: o.source, Line: o.line, Column: o.column, Setting: setting, Message: message}
+	}
+	switch settingValue.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return invalid("expected string")
+		}
+		settingValue.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return invalid("expected boolean")
+		}
+		settingValue.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, ok := integer(value)
+		if !ok {
+			return invalid("expected integer")
+		}
+		if settingValue.OverflowInt(i) {
+			return invalid("integer out of range")
+		}
+		settingValue.SetInt(i)
+	case reflect.Uint16:
+		i, ok := integer(value)
+		if !ok {
+			return invalid("expected integer")
+		}
+		if i < 0 || settingValue.OverflowUint(uint64(i)) {
+			return invalid("integer out of range")
+		}
+		settingValue.SetUint(uint64(i))
+	}
+	if c.origins == nil {
+		c.origins = map[string]origin{}
+	}
+	c.origins[setting] = o
+	return nil
+}
+
+func integer(value interface{}) (int64, bool) {
+	switch i := value.(type) {
+	case int:
+		return int64(i), true
+	case int64:
+		return i, true
+	case uint64:
+		return int64(i), i <= 1<<63-1
+	}
+	return 0, false
+}
+
+// Validate returns Errors if some settings are invalid.
+func (c *Config) Validate() error {
+	var errs Errors
+	invalid := func(section, setting, format string, args ...interface{}) {
+		o := c.origins[section+"."+setting]
+		errs = append(errs, &Error{Source: o.source, Line: o.line, Column: o.column,
+			Setting: section + "." + setting, Message: fmt.Sprintf(format, args...)})
+	}
+	if c.Server.Port == 0 {
+		invalid("server", "port", "must be positive")
+	}
+	for _, address := range List(c.Server.Listen) {
+		if message := validateListenAddress(address); message != "" {
+			invalid("server", "listen", "%s: %s", address, message)
+		}
+	}
+	for _, address := range List(c.Server.AdminListen) {
+		if message := validateListenAddress(address); message != "" {
+			invalid("server", "adminListen", "%s: %s", address, message)
+		}
+	}
+	if !strings.HasPrefix(c.Server.RoutesPrefix, "/") || strings.HasSuffix(c.Server.RoutesPrefix, "/") {
+		invalid("server", "routesPrefix", "must start and must not end with /")
+	}
+	if c.Server.EventStreamTimeoutSeconds <= 0 {
+		invalid("server", "eventStreamTimeoutSeconds", "must be positive")
+	}
+	if c.Server.ShutdownTimeoutSeconds < 0 {
+		invalid("server", "shutdownTimeoutSeconds", "must not be negative")
+	}
+	if c.Server.ShutdownDelaySeconds < 0 {
+		invalid("server", "shutdownDelaySeconds", "must not be negative")
+	}
+	if c.Server.ShutdownRetrySeconds <= 0 {
+		invalid("server", "shutdownRetrySeconds", "must be positive")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		invalid("tls", "keyFile", "must be set together with tls.certFile")
+	}
+	switch c.TLS.ClientAuth {
+	case "none":
+	case "request", "require":
+		if c.TLS.ClientCAFile == "" {
+			invalid("tls", "clientCaFile", "must be set when client authentication is enabled")
+		}
+		if c.TLS.CertFile == "" {
+			invalid("tls", "clientAuth", "requires tls.certFile and tls.keyFile")
+		}
+	default:
+		invalid("tls", "clientAuth", "must be none, request or require")
+	}
+	for _, origin := range List(c.CORS.AllowedOrigins) {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				invalid("cors", "allowCredentials", "requires origins other than *")
+			}
+		} else if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			invalid("cors", "allowedOrigins", "%s: expected scheme://host[:port] or *", origin)
+		}
+	}
+	if c.CORS.MaxAgeSeconds < 0 {
+		invalid("cors", "maxAgeSeconds", "must not be negative")
+	}
+	if c.Messages.DefaultTtlSeconds < 0 {
+		invalid("messages", "defaultTtlSeconds", "must not be negative")
+	}
+	if ttls, err := IntMap(c.Messages.TopicTtlSeconds); err != nil {
+		invalid("messages", "topicTtlSeconds", "%s", err)
+	} else {
+		for _, topic := range sortedIntMapKeys(ttls) {
+			if ttls[topic] < 0 {
+				invalid("messages", "topicTtlSeconds", "%s: must not be negative", topic)
+			}
+		}
+	}
+	if c.Messages.IdempotencyWindowSeconds < 1 {
+		invalid("messages", "idempotencyWindowSeconds", "must be positive")
+	}
+	if c.Messages.RequestTimeoutSeconds < 1 {
+		invalid("messages", "requestTimeoutSeconds", "must be positive")
+	}
+	if c.Broker.EventQueueSize < 0 {
+		invalid("broker", "eventQueueSize", "must not be negative")
+	}
+	if c.Broker.SubscriberBufferSize < 0 {
+		invalid("broker", "subscriberBufferSize", "must not be negative")
+	}
+	if c.Broker.GroupBalancing != "roundRobin" && c.Broker.GroupBalancing != "leastLoaded" {
+		invalid("broker", "groupBalancing", "must be roundRobin or leastLoaded")
+	}
+	if c.Topics.Policy != "autoCreate" && c.Topics.Policy != "declared" {
+		invalid("topics", "policy", "must be autoCreate or declared")
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		invalid("webhooks", "maxAttempts", "must be positive")
+	}
+	if c.Webhooks.InitialBackoffSeconds < 1 {
+		invalid("webhooks", "initialBackoffSeconds", "must be positive")
+	}
+	if c.Webhooks.MaxBackoffSeconds < c.Webhooks.InitialBackoffSeconds {
+		invalid("webhooks", "maxBackoffSeconds", "must not be less than initialBackoffSeconds")
+	}
+	if c.Webhooks.TimeoutSeconds < 1 {
+		invalid("webhooks", "timeoutSeconds", "must be positive")
+	}
+	if c.Webhooks.QueueSize < 1 {
+		invalid("webhooks", "queueSize", "must be positive")
+	}
+	if c.Broker.AckTimeoutSeconds < 1 {
+		invalid("broker", "ackTimeoutSeconds", "must be positive")
+	}
+	if c.Broker.MaxDeliveryAttempts < 0 {
+		invalid("broker", "maxDeliveryAttempts", "must not be negative")
+	}
+	if c.Limits.MaxMessageSize < 0 {
+		invalid("limits", "maxMessageSize", "must not be negative")
+	}
+	if c.Limits.MaxScheduledMessages < 0 {
+		invalid("limits", "maxScheduledMessages", "must not be negative")
+	}
+	if c.Limits.MaxIdempotencyKeys < 0 {
+		invalid("limits", "maxIdempotencyKeys", "must not be negative")
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// List splits comma separated list setting omitting empty items.
+func List(setting string) []string {
+	var items []string
+	for _, item := range strings.Split(setting, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// IntMap parses comma separated list of key=integer items.
+func IntMap(setting string) (map[string]int, error) {
+	m := map[string]int{}
+	for _, item := range List(setting) {
+		separator := strings.LastIndex(item, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("%s: expected key=integer", item)
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(item[separator+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: expected key=integer", item)
+		}
+		m[strings.TrimSpace(item[:separator])] = i
+	}
+	return m, nil
+}
+
+func sortedIntMapKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func validateListenAddress(address string) string {
+	if strings.HasPrefix(address, "unix:") {
+		if address == "unix:" {
+			return "missing socket path"
+		}
+		return ""
+	}
+	if _, port, err := net.SplitHostPort(address); err != nil {
+		return "expected host:port or unix:path"
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "invalid port"
+	}
+	return ""
+}
+
+// Error describes invalid setting. Setting is the dotted setting path.
+// Source is file name or environment variable name the setting comes from.
+// Line and Column locate the setting in file if known.
+type Error struct {
+	Source  string
+	Line    int
+	Column  int
+	Setting string
+	Message string
+}
+
+func (e *Error) Error() string {
+	location := e.Source
+	if e.Line > 0 {
+		location += fmt.Sprintf(":%d", e.Line)
+		if e.Column > 0 {
+			location += fmt.Sprintf(":%d", e.Column)
+		}
+	}
+	message := e.Message
+	if e.Setting != "" {
+		message = e.Setting + ": " + message
+	}
+	if location == "" {
+		return message
+	}
+	return location + ": " + message
+}
+
+type Errors []*Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// settings calls settingFunc for every setting of the config.
+func (c *Config) settings(settingFunc func(section, setting string, value reflect.Value)) {
+	configValue := reflect.ValueOf(c).Elem()
+	for i := 0; i < configValue.NumField(); i++ {
+		section, ok := configValue.Type().Field(i).Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+		sectionValue := configValue.Field(i)
+		for j := 0; j < sectionValue.NumField(); j++ {
+			setting := sectionValue.Type().Field(j).Tag.Get("config")
+			settingFunc(section, setting, sectionValue.Field(j))
+		}
+	}
+}
+
+// EnvName returns name of environment variable overriding the setting.
+func EnvName(section, setting string) string {
+	return EnvPrefix + strings.ToUpper(section) + "_" + upperSnakeCase(setting)
+}
+
+func upperSnakeCase(name string) string {
+	builder := strings.Builder{}
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' && i > 0 {
+			builder.WriteRune('_')
+		}
+		builder.WriteRune(r)
+	}
+	return strings.ToUpper(builder.String())
+}
+
+// Diff returns dotted paths of settings having different values.
+func Diff(a, b Config) []string {
+	var settings []string
+	bSettings := map[string]reflect.Value{}
+	b.settings(func(section, setting string, value reflect.Value) {
+		bSettings[section+"."+setting] = value
+	})
+	a.settings(func(section, setting string, value reflect.Value) {
+		if value.Interface() != bSettings[section+"."+setting].Interface() {
+			settings = append(settings, section+"."+setting)
+		}
+	})
+	return settings
+}
+
+// Copy sets the setting given as dotted path to its value in source.
+func (c *Config) Copy(source Config, setting string) error {
+	target, ok := c.setting(setting)
+	if !ok {
+		return &Error{Setting: setting, Message: "unknown setting"}
+	}
+	value, _ := source.setting(setting)
+	target.Set(value)
+	if o, ok := source.origins[setting]; ok {
+		if c.origins == nil {
+			c.origins = map[string]origin{}
+		}
+		c.origins[setting] = o
+	}
+	return nil
+}
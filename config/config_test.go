@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLoadDefault(t *testing.T) {
+	config, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Default configuration is invalid: %q", err)
+	}
+	if config.Server != Default().Server {
+		t.Fatalf("Unexpected server configuration %v", config.Server)
+	}
+}
+
+func TestLoadValid(t *testing.T) {
+	for _, fileName := range []string{"testdata/valid.yaml", "testdata/valid.json", "testdata/valid.toml"} {
+		config, err := Load(fileName)
+		if err != nil {
+			t.Fatalf("Load %s failed: %q", fileName, err)
+		}
+		if err := config.Validate(); err != nil {
+			t.Fatalf("Configuration %s is invalid: %q", fileName, err)
+		}
+		if config.Server.Port != 9090 || config.Server.RoutesPrefix != "/events" ||
+			config.Server.EventStreamTimeoutSeconds != 60 || config.Server.ShutdownTimeoutSeconds != 30 {
+			t.Fatalf("Unexpected server configuration %v from %s", config.Server, fileName)
+		}
+		if config.Broker.EventQueueSize != 16 || config.Broker.SubscriberBufferSize != 1 {
+			t.Fatalf("Unexpected broker configuration %v from %s", config.Broker, fileName)
+		}
+		if config.Auth.AdminToken != "secret" || config.Limits.MaxMessageSize != 4096 {
+			t.Fatalf("Unexpected configuration %v from %s", config, fileName)
+		}
+	}
+}
+
+func TestLoadInvalidYaml(t *testing.T) {
+	_, err := Load("testdata/invalid.yaml")
+	const expected = "testdata/invalid.yaml:2:3: server.port: integer out of range\n" +
+		"testdata/invalid.yaml:4:3: server.eventStreamTimeout: unknown setting\n" +
+		"testdata/invalid.yaml:6:3: broker.eventQueueSize: expected integer"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+}
+
+func TestValidateInvalidToml(t *testing.T) {
+	config, err := Load("testdata/invalid.toml")
+	if err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	err = config.Validate()
+	const expected = "testdata/invalid.toml:2:1: server.port: must be positive\n" +
+		"testdata/invalid.toml:3:1: server.routesPrefix: must start and must not end with /"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+}
+
+func TestLoadSyntaxError(t *testing.T) {
+	_, err := Load("testdata/syntax.yaml")
+	if err == nil || err.Error() != "testdata/syntax.yaml:2: did not find expected key" {
+		t.Fatalf("Unexpected error %q", err)
+	}
+}
+
+func TestLoadUnsupportedExtension(t *testing.T) {
+	if _, err := Load("testdata/config.ini"); err == nil {
+		t.Fatal("Expected error for unsupported extension")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	_ = os.Setenv("INFOCENTER_SERVER_PORT", "7070")
+	_ = os.Setenv("INFOCENTER_AUTH_ADMIN_TOKEN", "env-secret")
+	defer func() {
+		_ = os.Unsetenv("INFOCENTER_SERVER_PORT")
+		_ = os.Unsetenv("INFOCENTER_AUTH_ADMIN_TOKEN")
+	}()
+	config, err := Load("testdata/valid.yaml")
+	if err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	if config.Server.Port != 7070 || config.Auth.AdminToken != "env-secret" ||
+		config.Server.RoutesPrefix != "/events" {
+		t.Fatalf("Unexpected configuration %v", config)
+	}
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+	_ = os.Setenv("INFOCENTER_SERVER_PORT", "0")
+	defer func() {
+		_ = os.Unsetenv("INFOCENTER_SERVER_PORT")
+	}()
+	config, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	err = config.Validate()
+	if err == nil || err.Error() != "INFOCENTER_SERVER_PORT: server.port: must be positive" {
+		t.Fatalf("Unexpected error %q", err)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	config := Default()
+	if err := config.Override("server.port", "abc", "--port"); err == nil ||
+		err.Error() != "--port: server.port: expected integer" {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if err := config.Override("server.unknown", "1", "--unknown"); err == nil {
+		t.Fatal("Expected error for unknown setting")
+	}
+	if err := config.Override("server.port", "1234", "--port"); err != nil {
+		t.Fatalf("Override failed: %q", err)
+	}
+	if config.Server.Port != 1234 {
+		t.Fatalf("Unexpected port %d", config.Server.Port)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := EnvName("server", "eventStreamTimeoutSeconds"); name != "INFOCENTER_SERVER_EVENT_STREAM_TIMEOUT_SECONDS" {
+		t.Fatalf("Unexpected environment variable name %q", name)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := Default()
+	b := Default()
+	b.Server.Port = 9090
+	b.Auth.AdminToken = "secret"
+	diff := Diff(a, b)
+	if len(diff) != 2 || diff[0] != "server.port" || diff[1] != "auth.adminToken" {
+		t.Fatalf("Unexpected difference %q", diff)
+	}
+	if err := a.Copy(b, "auth.adminToken"); err != nil {
+		t.Fatalf("Copy failed: %q", err)
+	}
+	if diff := Diff(a, b); len(diff) != 1 || diff[0] != "server.port" {
+		t.Fatalf("Unexpected difference %q", diff)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	config := Default()
+	if err := config.Override("tls.certFile", "server.crt", "--tls-cert"); err != nil {
+		t.Fatalf("Override failed: %q", err)
+	}
+	if err := config.Override("tls.clientAuth", "require", "--tls-client-auth"); err != nil {
+		t.Fatalf("Override failed: %q", err)
+	}
+	expected := "tls.keyFile: must be set together with tls.certFile\n" +
+		"tls.clientCaFile: must be set when client authentication is enabled"
+	if err := config.Validate(); err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+}
+
+func TestValidateListen(t *testing.T) {
+	config := Default()
+	if err := config.Override("server.listen", "127.0.0.1:8080, unix:/run/infocenter.sock,localhost",
+		"--listen"); err != nil {
+		t.Fatalf("Override failed: %q", err)
+	}
+	if err := config.Override("server.adminListen", "unix:", "--admin-listen"); err != nil {
+		t.Fatalf("Override failed: %q", err)
+	}
+	expected := "--listen: server.listen: localhost: expected host:port or unix:path\n" +
+		"--admin-listen: server.adminListen: unix:: missing socket path"
+	if err := config.Validate(); err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if addresses := List(config.Server.Listen); len(addresses) != 3 ||
+		addresses[1] != "unix:/run/infocenter.sock" {
+		t.Fatalf("Unexpected listen addresses %q", addresses)
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	config := Default()
+	config.CORS.AllowedOrigins = "*, app.example.com"
+	config.CORS.AllowCredentials = true
+	expected := "cors.allowCredentials: requires origins other than *\n" +
+		"cors.allowedOrigins: app.example.com: expected scheme://host[:port] or *"
+	if err := config.Validate(); err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+}
+
+func TestValidateMessages(t *testing.T) {
+	config := Default()
+	config.Messages.TopicTtlSeconds = "alerts=60, metrics=-1"
+	expected := "messages.topicTtlSeconds: metrics: must not be negative"
+	if err := config.Validate(); err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	config.Messages.TopicTtlSeconds = "alerts"
+	expected = "messages.topicTtlSeconds: alerts: expected key=integer"
+	if err := config.Validate(); err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	config.Messages.TopicTtlSeconds = ""
+	config.Messages.IdempotencyWindowSeconds = 0
+	expected = "messages.idempotencyWindowSeconds: must be positive"
+	if err := config.Validate(); err == nil || err.Error() != expected {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if ttls, err := IntMap("alerts=60, a=b=10"); err != nil || len(ttls) != 2 || ttls["alerts"] != 60 ||
+		ttls["a=b"] != 10 {
+		t.Fatalf("Unexpected map %v, error %v", ttls, err)
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileSetting is a setting value with its position in configuration file.
+type fileSetting struct {
+	setting string
+	value   interface{}
+	position
+}
+
+// decodeFile overrides settings by the configuration file. Returns Errors
+// locating every invalid setting in the file.
+func (c *Config) decodeFile(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return &Error{Source: fileName, Message: err.Error()}
+	}
+	var settings []fileSetting
+	var errs Errors
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml", ".json":
+		settings, errs = parseYaml(data)
+	case ".toml":
+		settings, errs = parseToml(data)
+	default:
+		return &Error{Source: fileName, Message: "unsupported configuration file extension, " +
+			"expected .yaml, .yml, .json or .toml"}
+	}
+	for _, s := range settings {
+		if err := c.set(s.setting, s.value, origin{source: fileName, position: s.position}); err != nil {
+			errs = append(errs, err.(*Error))
+		}
+	}
+	if len(errs) != 0 {
+		for _, err := range errs {
+			err.Source = fileName
+		}
+		return errs
+	}
+	return nil
+}
+
+var yamlLineRegexp = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// parseYaml parses YAML configuration. JSON is parsed as YAML too as YAML
+// is a superset of JSON.
+func parseYaml(data []byte) ([]fileSetting, Errors) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		syntaxError := &Error{Message: err.Error()}
+		if match := yamlLineRegexp.FindStringSubmatch(err.Error()); match != nil {
+			syntaxError.Line, _ = strconv.Atoi(match[1])
+			syntaxError.Message = err.Error()[len(match[0]):]
+		}
+		return nil, Errors{syntaxError}
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, Errors{yamlError(root, "", "expected mapping of sections")}
+	}
+	var settings []fileSetting
+	var errs Errors
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		sectionKey, section := root.Content[i], root.Content[i+1]
+		if section.Kind != yaml.MappingNode {
+			errs = append(errs, yamlError(section, sectionKey.Value, "expected mapping of settings"))
+			continue
+		}
+		for j := 0; j+1 < len(section.Content); j += 2 {
+			settingKey, settingValue := section.Content[j], section.Content[j+1]
+			setting := sectionKey.Value + "." + settingKey.Value
+			if settingValue.Kind != yaml.ScalarNode {
+				errs = append(errs, yamlError(settingValue, setting, "expected scalar value"))
+				continue
+			}
+			var value interface{}
+			if err := settingValue.Decode(&value); err != nil {
+				errs = append(errs, yamlError(settingValue, setting, err.Error()))
+				continue
+			}
+			settings = append(settings, fileSetting{
+				setting:  setting,
+				value:    value,
+				position: position{line: settingKey.Line, column: settingKey.Column},
+			})
+		}
+	}
+	return settings, errs
+}
+
+func yamlError(node *yaml.Node, setting string, message string) *Error {
+	return &Error{Line: node.Line, Column: node.Column, Setting: setting, Message: message}
+}
+
+var tomlTableRegexp = regexp.MustCompile(`^\s*\[\s*([A-Za-z0-9_-]+)\s*]`)
+var tomlKeyRegexp = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+)\s*=`)
+
+// parseToml parses TOML configuration. TOML decoder does not report
+// positions of keys, so they are looked up in lines of the file.
+func parseToml(data []byte) ([]fileSetting, Errors) {
+	var document map[string]interface{}
+	if _, err := toml.Decode(string(data), &document); err != nil {
+		syntaxError := &Error{Message: err.Error()}
+		if parseError, ok := err.(toml.ParseError); ok {
+			syntaxError.Line = parseError.Position.Line
+			syntaxError.Column = parseError.Position.Col
+			syntaxError.Message = parseError.Message
+		}
+		return nil, Errors{syntaxError}
+	}
+	positions := map[string]position{}
+	table := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		if match := tomlTableRegexp.FindStringSubmatch(line); match != nil {
+			table = match[1]
+			positions[table] = position{line: i + 1, column: strings.Index(line, "[") + 1}
+		} else if match := tomlKeyRegexp.FindStringSubmatch(line); match != nil {
+			key := match[2]
+			if table != "" {
+				key = table + "." + key
+			}
+			positions[key] = position{line: i + 1, column: len(match[1]) + 1}
+		}
+	}
+	var settings []fileSetting
+	var errs Errors
+	for _, sectionName := range sortedKeys(document) {
+		section, ok := document[sectionName].(map[string]interface{})
+		if !ok {
+			p := positions[sectionName]
+			errs = append(errs, &Error{Line: p.line, Column: p.column, Setting: sectionName,
+				Message: "expected table of settings"})
+			continue
+		}
+		for _, settingName := range sortedKeys(section) {
+			setting := sectionName + "." + settingName
+			settings = append(settings, fileSetting{
+				setting:  setting,
+				value:    section[settingName],
+				position: positions[setting],
+			})
+		}
+	}
+	return settings, errs
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
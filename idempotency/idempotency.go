@@ -0,0 +1,271 @@
+// Store of responses to requests with idempotency keys.
+//
+// A request with a key seen within the window gets the response of the
+// first request instead of being processed again. Keys are scoped by topic.
+// The number of remembered keys is bounded, the oldest ones are forgotten
+// first. If the store has a file, keys are written to it periodically and
+// on Stop, so that they survive restarts.
+package idempotency
+
+import (
+	"encoding/json"
+	"github.com/vaidasn/infocenter/atomicfile"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// persistInterval is the period of writing changed keys to the file.
+const persistInterval = 5 * time.Second
+
+// Result tells what Begin found for the key.
+type Result int
+
+const (
+	// Reserved means the key is new and the request should be processed
+	// and finished by Complete or Abort.
+	Reserved Result = iota
+	// Replayed means the response of an earlier request is returned.
+	Replayed
+	// InProgress means an earlier request with the key is being processed.
+	InProgress
+)
+
+// Response is the remembered response to a request.
+type Response struct {
+	MessageID   string    `json:"messageId,omitempty"`
+	StatusCode  int       `json:"statusCode"`
+	ContentType string    `json:"contentType,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	Expires     time.Time `json:"expires"`
+}
+
+type entry struct {
+	Topic    string   `json:"topic"`
+	Key      string   `json:"key"`
+	Response Response `json:"response"`
+}
+
+type scopedKey struct {
+	topic string
+	key   string
+}
+
+// record is the response to a key or reservation of a key in progress.
+// Sequence number tells which completion of the key in order is current.
+type record struct {
+	response Response
+	reserved bool
+	sequence uint64
+}
+
+type orderedKey struct {
+	scopedKey
+	sequence uint64
+}
+
+type Store struct {
+	window   time.Duration
+	maxKeys  int
+	fileName string
+	mutex    sync.Mutex
+	records  map[scopedKey]*record
+	// order lists completed keys from the oldest. Keys forgotten or
+	// completed again later are skipped.
+	order    []orderedKey
+	sequence uint64
+	changed  bool
+	// loaded, started and stopped tell whether Load, Start and Stop were
+	// called, so that Stop does not wait for Start which never runs.
+	loaded   bool
+	started  bool
+	stopped  bool
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	doneCh   chan struct{}
+}
+
+// New returns store remembering responses for window and at most maxKeys
+// keys if it is positive. Keys are persisted to fileName unless it is
+// empty.
+func New(window time.Duration, maxKeys int, fileName string) *Store {
+	return &Store{
+		window:   window,
+		maxKeys:  maxKeys,
+		fileName: fileName,
+		records:  map[scopedKey]*record{},
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Load adds keys persisted earlier to keys of requests begun since New,
+// which take precedence. Load must be called before Start, which writes the
+// file, and it may be delayed until another process persisting to the file
+// stops.
+func (s *Store) Load() error {
+	if s.fileName == "" {
+		return nil
+	}
+	var entries []entry
+	data, err := os.ReadFile(s.fileName)
+	if err == nil {
+		err = json.Unmarshal(data, &entries)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.loaded = true
+	loaded := make([]orderedKey, 0, len(entries))
+	for _, e := range entries {
+		k := scopedKey{e.Topic, e.Key}
+		if _, ok := s.records[k]; ok {
+			continue
+		}
+		s.sequence++
+		s.records[k] = &record{response: e.Response, sequence: s.sequence}
+		loaded = append(loaded, orderedKey{k, s.sequence})
+	}
+	// Loaded keys are older than keys completed since New.
+	s.order = append(loaded, s.order...)
+	s.forget(time.Now())
+	return nil
+}
+
+// Start writes changed keys to the file periodically until Stop is called.
+// It returns at once if Stop was called already.
+func (s *Store) Start() {
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return
+	}
+	s.started = true
+	s.mutex.Unlock()
+	defer close(s.doneCh)
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.persistChanged()
+		case <-s.stopCh:
+			s.persistChanged()
+			return
+		}
+	}
+}
+
+// Stop writes changed keys to the file and stops Start. If Start has not
+// been called, Stop returns at once and writes the file only if Load was
+// called, as another process may still own the file otherwise. Stop may be called more than once.
+func (s *Store) Stop() {
+	s.mutex.Lock()
+	s.stopped = true
+	loaded, started := s.loaded, s.started
+	s.mutex.Unlock()
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	if started {
+		<-s.doneCh
+	} else if loaded {
+		s.persistChanged()
+	}
+}
+
+// Begin returns the remembered response to the key of the topic if there
+// is one. Otherwise it reserves the key unless another request with the
+// key is in progress.
+func (s *Store) Begin(topic string, key string) (Response, Result) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := scopedKey{topic, key}
+	if r, ok := s.records[k]; ok {
+		if r.reserved {
+			return Response{}, InProgress
+		}
+		if r.response.Expires.After(time.Now()) {
+			return r.response, Replayed
+		}
+	}
+	s.records[k] = &record{reserved: true}
+	return Response{}, Reserved
+}
+
+// Complete remembers the response to the reserved key for the window.
+func (s *Store) Complete(topic string, key string, response Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	response.Expires = now.Add(s.window)
+	s.add(scopedKey{topic, key}, response)
+	s.forget(now)
+	s.changed = true
+}
+
+// Abort releases the reserved key without remembering a response, so that
+// the request may be retried.
+func (s *Store) Abort(topic string, key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.records, scopedKey{topic, key})
+}
+
+func (s *Store) add(k scopedKey, response Response) {
+	s.sequence++
+	s.records[k] = &record{response: response, sequence: s.sequence}
+	s.order = append(s.order, orderedKey{k, s.sequence})
+}
+
+// current returns record of the ordered key unless the key was forgotten,
+// reserved or completed again later.
+func (s *Store) current(k orderedKey) *record {
+	if r, ok := s.records[k.scopedKey]; ok && !r.reserved && r.sequence == k.sequence {
+		return r
+	}
+	return nil
+}
+
+// forget removes expired keys and the oldest keys exceeding maxKeys.
+func (s *Store) forget(now time.Time) {
+	for len(s.order) != 0 {
+		k := s.order[0]
+		r := s.current(k)
+		if r != nil && r.response.Expires.After(now) && (s.maxKeys <= 0 || len(s.records) <= s.maxKeys) {
+			break
+		}
+		s.order = s.order[1:]
+		if r != nil {
+			delete(s.records, k.scopedKey)
+		}
+	}
+}
+
+func (s *Store) persistChanged() {
+	if s.fileName == "" {
+		return
+	}
+	s.mutex.Lock()
+	if !s.changed {
+		s.mutex.Unlock()
+		return
+	}
+	s.forget(time.Now())
+	entries := make([]entry, 0, len(s.order))
+	for _, k := range s.order {
+		if r := s.current(k); r != nil {
+			entries = append(entries, entry{Topic: k.topic, Key: k.key, Response: r.response})
+		}
+	}
+	s.changed = false
+	s.mutex.Unlock()
+	if err := atomicfile.WriteJSON(s.fileName, entries); err != nil {
+		log.Println("Persisting idempotency keys failed: ", err)
+	}
+}
@@ -0,0 +1,98 @@
+package idempotency
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s := New(time.Hour, 0, "")
+	if _, result := s.Begin("topic", "key"); result != Reserved {
+		t.Fatalf("Begin returned %v but expected %v", result, Reserved)
+	}
+	if _, result := s.Begin("topic", "key"); result != InProgress {
+		t.Fatalf("Begin returned %v but expected %v", result, InProgress)
+	}
+	if _, result := s.Begin("other", "key"); result != Reserved {
+		t.Fatalf("Begin of another topic returned %v but expected %v", result, Reserved)
+	}
+	s.Abort("other", "key")
+	if _, result := s.Begin("other", "key"); result != Reserved {
+		t.Fatalf("Begin after Abort returned %v but expected %v", result, Reserved)
+	}
+	s.Complete("topic", "key", Response{MessageID: "1", StatusCode: 204})
+	response, result := s.Begin("topic", "key")
+	if result != Replayed || response.MessageID != "1" || response.StatusCode != 204 {
+		t.Fatalf("Begin returned %v, %v", response, result)
+	}
+}
+
+func TestStoreForgets(t *testing.T) {
+	s := New(time.Hour, 2, "")
+	for _, key := range []string{"a", "b", "c"} {
+		s.Begin("topic", key)
+		s.Complete("topic", key, Response{MessageID: key, StatusCode: 204})
+	}
+	if _, result := s.Begin("topic", "a"); result != Reserved {
+		t.Fatalf("Oldest key was not forgotten")
+	}
+	if _, result := s.Begin("topic", "c"); result != Replayed {
+		t.Fatalf("Newest key was forgotten")
+	}
+
+	s = New(time.Millisecond, 0, "")
+	s.Begin("topic", "a")
+	s.Complete("topic", "a", Response{StatusCode: 204})
+	time.Sleep(10 * time.Millisecond)
+	if _, result := s.Begin("topic", "a"); result != Reserved {
+		t.Fatalf("Expired key was not forgotten")
+	}
+	s.Complete("topic", "a", Response{StatusCode: 204})
+	s.Begin("topic", "b")
+	s.Complete("topic", "b", Response{StatusCode: 204})
+	if _, result := s.Begin("topic", "a"); result != Replayed {
+		t.Fatalf("Key completed again was forgotten")
+	}
+}
+
+func TestStoreStopWithoutStart(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "idempotency.json")
+	s := New(time.Hour, 0, fileName)
+	s.Begin("topic", "key")
+	s.Complete("topic", "key", Response{MessageID: "1", StatusCode: 204})
+	s.Stop()
+	s.Start()
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Fatalf("Expected store not started to leave the file alone but got %v", err)
+	}
+}
+
+func TestStorePersistence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "idempotency.json")
+	s := New(time.Hour, 0, fileName)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	go s.Start()
+	s.Begin("topic", "key")
+	s.Complete("topic", "key", Response{MessageID: "1", StatusCode: 202, ContentType: "application/json",
+		Body: []byte("{}")})
+	s.Stop()
+
+	s = New(time.Hour, 0, fileName)
+	s.Begin("topic", "new")
+	s.Complete("topic", "new", Response{MessageID: "2", StatusCode: 204})
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	response, result := s.Begin("topic", "key")
+	if result != Replayed || response.MessageID != "1" || response.StatusCode != 202 ||
+		response.ContentType != "application/json" || string(response.Body) != "{}" {
+		t.Fatalf("Begin returned %v, %v", response, result)
+	}
+	if response, result = s.Begin("topic", "new"); result != Replayed || response.MessageID != "2" {
+		t.Fatalf("Begin of key completed before Load returned %v, %v", response, result)
+	}
+}
@@ -0,0 +1,258 @@
+// Inbound webhooks of third-party systems turned into topic messages.
+//
+// A source verifies the HMAC-SHA256 signature of posted payloads by its
+// secret and maps them by templates as in text/template to topic, event
+// type and message. Scheme hmac-sha256 expects SignatureHeader with
+// SignaturePrefix followed by hex encoded signature of the body, e.g.
+// X-Hub-Signature-256: sha256=<hex> of GitHub. Scheme stripe expects
+// SignatureHeader such as Stripe-Signature: t=<unix time>,v1=<hex> with
+// signature of the time, a dot and the body, not older than Tolerance.
+//
+// Templates are executed with Source name, raw Body, Payload decoded from
+// JSON body or nil if it is not JSON and Header method returning request
+// header value, e.g. github.{{.Header "X-GitHub-Event"}} or
+// {{.Payload.repository.full_name}}. Referring to missing payload fields
+// fails mapping.
+package inbound
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	HmacSha256 = "hmac-sha256"
+	Stripe     = "stripe"
+	// DefaultTolerance is the maximum age of stripe scheme signatures of
+	// sources without Tolerance.
+	DefaultTolerance = 300
+)
+
+// Source of inbound webhooks.
+type Source struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+	// Scheme is hmac-sha256 if empty.
+	Scheme          string `json:"scheme"`
+	SignatureHeader string `json:"signatureHeader"`
+	SignaturePrefix string `json:"signaturePrefix"`
+	// Tolerance is the maximum age of stripe scheme signatures in seconds.
+	Tolerance int `json:"tolerance"`
+	// Topic is the template of the topic.
+	Topic string `json:"topic"`
+	// Event is the template of the event type. Messages are of the default
+	// type if it is empty or executes to empty string.
+	Event string `json:"event"`
+	// Message is the template of the message. The message is the body if it
+	// is empty.
+	Message string `json:"message"`
+}
+
+// Message mapped from a payload.
+type Message struct {
+	Topic   string
+	Event   string
+	Message string
+}
+
+var (
+	// ErrInvalidSource is returned by New for sources without name, secret
+	// or topic, with unknown scheme or invalid templates.
+	ErrInvalidSource = errors.New("invalid inbound source")
+	// ErrUnknownSource is returned by Map for sources not configured.
+	ErrUnknownSource = errors.New("unknown inbound source")
+	// ErrInvalidSignature is returned by Map for payloads without valid
+	// signature.
+	ErrInvalidSignature = errors.New("invalid inbound signature")
+	// ErrInvalidPayload is returned by Map for payloads the templates fail
+	// to map or mapped to invalid topic or event type.
+	ErrInvalidPayload = errors.New("invalid inbound payload")
+)
+
+type source struct {
+	Source
+	topic   *template.Template
+	event   *template.Template
+	message *template.Template
+}
+
+// Adapter maps payloads of sources to messages.
+type Adapter struct {
+	sources map[string]*source
+}
+
+// Load returns sources of JSON file.
+func Load(fileName string) ([]Source, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&sources); err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return sources, nil
+}
+
+// New validates the sources and returns adapter of them.
+func New(sources []Source) (*Adapter, error) {
+	a := &Adapter{sources: map[string]*source{}}
+	for _, s := range sources {
+		if s.Name == "" || s.Secret == "" || s.Topic == "" {
+			return nil, fmt.Errorf("%w %q: name, secret and topic are required", ErrInvalidSource, s.Name)
+		}
+		if _, ok := a.sources[s.Name]; ok {
+			return nil, fmt.Errorf("%w %q: duplicate name", ErrInvalidSource, s.Name)
+		}
+		if s.Scheme == "" {
+			s.Scheme = HmacSha256
+		}
+		if s.Scheme != HmacSha256 && s.Scheme != Stripe {
+			return nil, fmt.Errorf("%w %q: unknown scheme %q", ErrInvalidSource, s.Name, s.Scheme)
+		}
+		if s.SignatureHeader == "" {
+			return nil, fmt.Errorf("%w %q: signatureHeader is required", ErrInvalidSource, s.Name)
+		}
+		if s.Tolerance < 0 {
+			return nil, fmt.Errorf("%w %q: tolerance must not be negative", ErrInvalidSource, s.Name)
+		} else if s.Tolerance == 0 {
+			s.Tolerance = DefaultTolerance
+		}
+		compiled := &source{Source: s}
+		for _, t := range []struct {
+			field    string
+			text     string
+			template **template.Template
+		}{
+			{"topic", s.Topic, &compiled.topic},
+			{"event", s.Event, &compiled.event},
+			{"message", s.Message, &compiled.message},
+		} {
+			if t.text == "" {
+				continue
+			}
+			parsed, err := template.New(t.field).Option("missingkey=error").Parse(t.text)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %s: %s", ErrInvalidSource, s.Name, t.field, err)
+			}
+			*t.template = parsed
+		}
+		a.sources[s.Name] = compiled
+	}
+	return a, nil
+}
+
+// Has returns true if the source is configured.
+func (a *Adapter) Has(name string) bool {
+	_, ok := a.sources[name]
+	return ok
+}
+
+// payload is the data templates are executed with.
+type payload struct {
+	Source  string
+	Body    string
+	Payload interface{}
+	header  http.Header
+}
+
+// Header returns the first value of the request header.
+func (p payload) Header(name string) string {
+	return p.header.Get(name)
+}
+
+// Map verifies the signature of the body posted by the source at the time
+// and maps it to a message.
+func (a *Adapter) Map(name string, header http.Header, body []byte, now time.Time) (Message, error) {
+	s, ok := a.sources[name]
+	if !ok {
+		return Message{}, ErrUnknownSource
+	}
+	if !s.verify(header.Get(s.SignatureHeader), body, now) {
+		return Message{}, ErrInvalidSignature
+	}
+	data := payload{Source: name, Body: string(body), header: header}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data.Payload); err != nil {
+		data.Payload = nil
+	}
+	m := Message{Message: data.Body}
+	for _, t := range []struct {
+		template *template.Template
+		value    *string
+	}{{s.topic, &m.Topic}, {s.event, &m.Event}, {s.message, &m.Message}} {
+		if t.template == nil {
+			continue
+		}
+		var buffer strings.Builder
+		if err := t.template.Execute(&buffer, data); err != nil {
+			return Message{}, fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+		}
+		*t.value = buffer.String()
+	}
+	if m.Topic == "" || strings.ContainsAny(m.Topic, "/\r\n") {
+		return Message{}, fmt.Errorf("%w: topic %q", ErrInvalidPayload, m.Topic)
+	}
+	if strings.ContainsAny(m.Event, "\r\n") {
+		return Message{}, fmt.Errorf("%w: event %q", ErrInvalidPayload, m.Event)
+	}
+	return m, nil
+}
+
+func (s *source) verify(signature string, body []byte, now time.Time) bool {
+	if s.Scheme == HmacSha256 {
+		if !strings.HasPrefix(signature, s.SignaturePrefix) {
+			return false
+		}
+		return validSignature(s.Secret, strings.TrimPrefix(signature, s.SignaturePrefix), body)
+	}
+	timestamp := ""
+	var signatures []string
+	for _, element := range strings.Split(signature, ",") {
+		keyValue := strings.SplitN(strings.TrimSpace(element), "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		switch keyValue[0] {
+		case "t":
+			timestamp = keyValue[1]
+		case "v1":
+			signatures = append(signatures, keyValue[1])
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(seconds, 0)) > time.Duration(s.Tolerance)*time.Second {
+		return false
+	}
+	signed := append([]byte(timestamp+"."), body...)
+	for _, v1 := range signatures {
+		if validSignature(s.Secret, v1, signed) {
+			return true
+		}
+	}
+	return false
+}
+
+func validSignature(secret string, hexSignature string, body []byte) bool {
+	signature, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sign(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestMap(t *testing.T) {
+	a, err := New([]Source{{
+		Name:            "github",
+		Secret:          "secret",
+		SignatureHeader: "X-Hub-Signature-256",
+		SignaturePrefix: "sha256=",
+		Topic:           `github.{{.Payload.repository.name}}`,
+		Event:           `{{.Header "X-GitHub-Event"}}`,
+	}, {
+		Name:            "stripe",
+		Secret:          "secret",
+		Scheme:          Stripe,
+		SignatureHeader: "Stripe-Signature",
+		Topic:           "payments",
+		Event:           "{{.Payload.type}}",
+		Message:         "{{.Payload.data.amount}}",
+	}})
+	if err != nil {
+		t.Fatalf("New failed: %q", err)
+	}
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	oldTimestamp := strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)
+	githubBody := `{"repository":{"name":"infocenter"}}`
+	stripeBody := `{"type":"charge.succeeded","data":{"amount":1250}}`
+	for _, test := range []struct {
+		source    string
+		header    http.Header
+		body      string
+		expected  Message
+		expectErr error
+	}{
+		{"github", http.Header{"X-Hub-Signature-256": {"sha256=" + sign("secret", githubBody)},
+			"X-Github-Event": {"push"}}, githubBody,
+			Message{Topic: "github.infocenter", Event: "push", Message: githubBody}, nil},
+		{"github", http.Header{"X-Hub-Signature-256": {"sha256=" + sign("other", githubBody)}}, githubBody,
+			Message{}, ErrInvalidSignature},
+		{"github", http.Header{"X-Hub-Signature-256": {sign("secret", githubBody)}}, githubBody,
+			Message{}, ErrInvalidSignature},
+		{"github", http.Header{"X-Hub-Signature-256": {"sha256=" + sign("secret", "{}")}}, "{}",
+			Message{}, ErrInvalidPayload},
+		{"stripe", http.Header{"Stripe-Signature": {"t=" + timestamp + ",v1=00,v1=" +
+			sign("secret", timestamp+"."+stripeBody)}}, stripeBody,
+			Message{Topic: "payments", Event: "charge.succeeded", Message: "1250"}, nil},
+		{"stripe", http.Header{"Stripe-Signature": {"t=" + oldTimestamp + ",v1=" +
+			sign("secret", oldTimestamp+"."+stripeBody)}}, stripeBody, Message{}, ErrInvalidSignature},
+		{"stripe", http.Header{"Stripe-Signature": {"v1=" + sign("secret", stripeBody)}}, stripeBody,
+			Message{}, ErrInvalidSignature},
+		{"other", http.Header{}, "", Message{}, ErrUnknownSource},
+	} {
+		m, err := a.Map(test.source, test.header, []byte(test.body), now)
+		if !errors.Is(err, test.expectErr) || m != test.expected {
+			t.Errorf("Mapping %s %q returned %v with %v but expected %v with %v", test.source, test.body, m, err,
+				test.expected, test.expectErr)
+		}
+	}
+	if !a.Has("github") || a.Has("other") {
+		t.Error("Expected only configured sources to be known")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	valid := Source{Name: "source", Secret: "secret", SignatureHeader: "X-Signature", Topic: "topic"}
+	for _, modify := range []func(s *Source){
+		func(s *Source) { s.Secret = "" },
+		func(s *Source) { s.Topic = "" },
+		func(s *Source) { s.SignatureHeader = "" },
+		func(s *Source) { s.Scheme = "md5" },
+		func(s *Source) { s.Tolerance = -1 },
+		func(s *Source) { s.Message = "{{.Payload" },
+	} {
+		s := valid
+		modify(&s)
+		if _, err := New([]Source{s}); !errors.Is(err, ErrInvalidSource) {
+			t.Errorf("Expected source %v to be invalid but got %v", s, err)
+		}
+	}
+	if _, err := New([]Source{valid, valid}); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("Expected duplicate source to be invalid but got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "sources.json")
+	if err := os.WriteFile(fileName, []byte(`[{"name":"source","secret":"secret",`+
+		`"signatureHeader":"X-Signature","topic":"topic"}]`), 0600); err != nil {
+		t.Fatalf("Writing sources failed: %q", err)
+	}
+	sources, err := Load(fileName)
+	if err != nil || len(sources) != 1 || sources[0].Name != "source" || sources[0].SignatureHeader != "X-Signature" {
+		t.Fatalf("Unexpected sources %v with %v", sources, err)
+	}
+}
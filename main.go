@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	flag "github.com/spf13/pflag"
+	"github.com/vaidasn/infocenter/config"
 	"github.com/vaidasn/infocenter/server"
 	"os"
 	"strings"
@@ -17,20 +18,116 @@ func init() {
 	}
 }
 
+// flagSettings maps command line options to configuration settings they
+// override.
+var flagSettings = map[string]string{
+	"port":             "server.port",
+	"listen":           "server.listen",
+	"admin-listen":     "server.adminListen",
+	"admin-token":      "auth.adminToken",
+	"shutdown-timeout": "server.shutdownTimeoutSeconds",
+	"shutdown-delay":   "server.shutdownDelaySeconds",
+	"tls-cert":         "tls.certFile",
+	"tls-key":          "tls.keyFile",
+	"tls-client-ca":    "tls.clientCaFile",
+	"tls-client-auth":  "tls.clientAuth",
+	"h2c":              "server.h2c",
+}
+
 func main() {
 	flag.Usage = func() {
 		_, _ = fmt.Fprint(os.Stderr, "Usage of infocenter:\n"+
 			"    infocenter [options]\n"+
+			"    infocenter config validate [options] [config-file]\n"+
 			"Options:\n")
 		flag.PrintDefaults()
 		_, _ = fmt.Fprintln(os.Stderr,
-			"\nInfocenter server application that uses server-sent events")
+			"\nInfocenter server application that uses server-sent events.\n"+
+				"Settings are read from configuration file given by --config and\n"+
+				"overridden by "+config.EnvPrefix+"<SECTION>_<SETTING> environment variables\n"+
+				"and then by options.")
+	}
+	defaults := config.Default()
+	configFile := flag.StringP("config", "c", "", "configuration file (.yaml, .yml, .json or .toml)")
+	flag.Uint16P("port", "p", defaults.Server.Port, "port to listen on")
+	flag.StringSlice("listen", config.List(defaults.Server.Listen),
+		"host:port or unix:path addresses to listen on instead of all interfaces at --port")
+	flag.StringSlice("admin-listen", config.List(defaults.Server.AdminListen),
+		"addresses serving admin API, metrics and publishing, other addresses serve only event streams")
+	flag.String("admin-token", defaults.Auth.AdminToken,
+		"bearer token required by admin API, admin API is disabled if empty")
+	flag.Int("shutdown-timeout", defaults.Server.ShutdownTimeoutSeconds,
+		"seconds to wait for event streams to close on SIGTERM or SIGINT")
+	flag.Int("shutdown-delay", defaults.Server.ShutdownDelaySeconds,
+		"seconds between failing readiness and shutting down on SIGTERM or SIGINT")
+	flag.String("tls-cert", defaults.TLS.CertFile, "TLS certificate file, enables HTTPS together with --tls-key")
+	flag.String("tls-key", defaults.TLS.KeyFile, "TLS private key file")
+	flag.String("tls-client-ca", defaults.TLS.ClientCAFile, "certificate authorities of client certificates")
+	flag.String("tls-client-auth", defaults.TLS.ClientAuth,
+		"client certificate authentication: none, request or require")
+	flag.Bool("h2c", defaults.Server.H2C, "serve HTTP/2 without TLS to clients with prior knowledge")
+	flag.ParseAll(func(f *flag.Flag, value string) error { return flag.Set(f.Name, value) })
+
+	args := flag.Args()
+	if len(args) > 0 {
+		if len(args) < 2 || len(args) > 3 || args[0] != "config" || args[1] != "validate" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if len(args) == 3 {
+			*configFile = args[2]
+		}
+		loadConfig(*configFile)
+		if *configFile == "" {
+			fmt.Println("Configuration is valid")
+		} else {
+			fmt.Printf("Configuration %s is valid\n", *configFile)
+		}
+		return
+	}
+
+	c := loadConfig(*configFile)
+	if c.Server.Listen == "" {
+		fmt.Printf("Listen on port %d\n", c.Server.Port)
+	} else {
+		fmt.Printf("Listen on %s\n", c.Server.Listen)
 	}
-	port := flag.Uint16P("port", "p", 8080, "port to listen on")
-	flag.ParseAll(func(_ *flag.Flag, _ string) error { return nil })
-	fmt.Printf("Listen on port %d\n", *port)
 	if infocenterDryRun {
 		return
 	}
-	server.ListenAndServe(*port)
+	server.Configure(c)
+	server.ConfigLoader = func() (config.Config, error) {
+		return readConfig(*configFile)
+	}
+	server.ListenAndServe(c.Server.Port)
+}
+
+// loadConfig returns validated configuration or exits reporting errors.
+func loadConfig(configFile string) config.Config {
+	c, err := readConfig(configFile)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return c
+}
+
+// readConfig returns validated configuration overridden by options.
+func readConfig(configFile string) (config.Config, error) {
+	c, err := config.Load(configFile)
+	if err == nil {
+		flag.Visit(func(f *flag.Flag) {
+			if setting, ok := flagSettings[f.Name]; ok && err == nil {
+				value := f.Value.String()
+				if sliceValue, ok := f.Value.(flag.SliceValue); ok {
+					value = strings.Join(sliceValue.GetSlice(), ",")
+				}
+				err = c.Override(setting, value, "--"+f.Name)
+			}
+		})
+	}
+	if err == nil {
+		err = c.Validate()
+	}
+	return c, err
 }
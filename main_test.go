@@ -38,3 +38,43 @@ func TestInfocenterHelp(t *testing.T) {
 		t.Fatalf("Expected stderr %q to contain %q", c.Stderr(), expectedMessage)
 	}
 }
+
+func TestInfocenterPortOption(t *testing.T) {
+	c := testcli.Command("infocenter", "--port", "9090")
+	c.SetEnv([]string{"GODEBUG=infocenterDryRun=1"})
+	c.Run()
+	if !c.Success() {
+		t.Fatalf("Expected to succeed, but failed: %s", c.Error())
+	}
+
+	if !c.StdoutContains("port 9090") {
+		t.Fatalf("Expected stdout %q to contain %q", c.Stdout(), "port 9090")
+	}
+}
+
+func TestInfocenterConfigFile(t *testing.T) {
+	c := testcli.Command("infocenter", "--config", "config/testdata/valid.toml")
+	c.SetEnv([]string{"GODEBUG=infocenterDryRun=1"})
+	c.Run()
+	if !c.Success() {
+		t.Fatalf("Expected to succeed, but failed: %s", c.Error())
+	}
+
+	if !c.StdoutContains("port 9090") {
+		t.Fatalf("Expected stdout %q to contain %q", c.Stdout(), "port 9090")
+	}
+}
+
+func TestInfocenterConfigValidate(t *testing.T) {
+	c := testcli.Command("infocenter", "config", "validate", "config/testdata/invalid.toml")
+	c.SetEnv([]string{"GODEBUG=infocenterDryRun=1"})
+	c.Run()
+	if !c.Failure() {
+		t.Fatalf("Expected to return non zero return code, but failed: %s", c.Error())
+	}
+
+	const expectedMessage = "config/testdata/invalid.toml:2:1: server.port: must be positive"
+	if !c.StderrContains(expectedMessage) {
+		t.Fatalf("Expected stderr %q to contain %q", c.Stderr(), expectedMessage)
+	}
+}
@@ -0,0 +1,328 @@
+// Minimal metrics collection exposed in Prometheus text exposition format
+// as specified on https://prometheus.io/docs/instrumenting/exposition_formats/.
+//
+// Only counters, gauges and histograms are supported. Every metric is a
+// vector and label values are passed to its update methods in order of
+// label names given at construction. A metric without label names is
+// updated without label values.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Collector interface {
+	writeMetrics(w *bufio.Writer)
+}
+
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) MustRegister(collectors ...Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mutex.Unlock()
+	bufferedWriter := bufio.NewWriter(w)
+	for _, collector := range collectors {
+		collector.writeMetrics(bufferedWriter)
+	}
+	return bufferedWriter.Flush()
+}
+
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", ContentType)
+	writer.WriteHeader(http.StatusOK)
+	if err := r.Write(writer); err != nil {
+		log.Println("Writing metrics failed: ", err)
+	}
+}
+
+type desc struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.metricType)
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	count       uint64
+}
+
+type vec struct {
+	desc
+	upperBounds []float64
+	mutex       sync.Mutex
+	series      map[string]*series
+}
+
+func newVec(name, help, metricType string, labelNames []string) vec {
+	return vec{
+		desc:   desc{name: name, help: help, metricType: metricType, labelNames: labelNames},
+		series: map[string]*series{},
+	}
+}
+
+func (v *vec) update(labelValues []string, updateFunc func(s *series)) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values but got %d",
+			v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if v.upperBounds != nil {
+			s.buckets = make([]uint64, len(v.upperBounds))
+		}
+		v.series[key] = s
+	}
+	updateFunc(s)
+}
+
+func (v *vec) value(labelValues []string) float64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if s, ok := v.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *vec) DeleteLabelValues(labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.series, strings.Join(labelValues, "\xff"))
+}
+
+// DeleteLabelValue deletes all series with the value of the label and
+// returns the number of series deleted.
+func (v *vec) DeleteLabelValue(labelName, labelValue string) int {
+	index := -1
+	for i, name := range v.labelNames {
+		if name == labelName {
+			index = i
+		}
+	}
+	if index < 0 {
+		return 0
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	deleted := 0
+	for key, s := range v.series {
+		if s.labelValues[index] == labelValue {
+			delete(v.series, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (v *vec) sortedSeries() []series {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	sorted := make([]series, 0, len(v.series))
+	for _, s := range v.series {
+		sorted = append(sorted, series{
+			labelValues: s.labelValues,
+			value:       s.value,
+			buckets:     append([]uint64(nil), s.buckets...),
+			count:       s.count,
+		})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Join(sorted[i].labelValues, "\xff") < strings.Join(sorted[j].labelValues, "\xff")
+	})
+	return sorted
+}
+
+func (v *vec) writeMetrics(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sortedSeries() {
+		labels := formatLabels(v.labelNames, s.labelValues, "", "")
+		if v.upperBounds == nil {
+			_, _ = fmt.Fprintf(w, "%s%s %s\n", v.name, labels, formatValue(s.value))
+			continue
+		}
+		cumulativeCount := uint64(0)
+		for i, upperBound := range v.upperBounds {
+			cumulativeCount += s.buckets[i]
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", v.name,
+				formatLabels(v.labelNames, s.labelValues, "le", formatValue(upperBound)), cumulativeCount)
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", v.name,
+			formatLabels(v.labelNames, s.labelValues, "le", "+Inf"), s.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labels, formatValue(s.value))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", v.name, labels, s.count)
+	}
+}
+
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labelNames)}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+	c.update(labelValues, func(s *series) {
+		s.value += delta
+	})
+}
+
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.value(labelValues)
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labelNames)}
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(s *series) {
+		s.value = value
+	})
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.update(labelValues, func(s *series) {
+		s.value += delta
+	})
+}
+
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.value(labelValues)
+}
+
+type HistogramVec struct {
+	vec
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, "histogram", labelNames)}
+	h.upperBounds = append([]float64(nil), buckets...)
+	sort.Float64s(h.upperBounds)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.update(labelValues, func(s *series) {
+		for i, upperBound := range h.upperBounds {
+			if value <= upperBound {
+				s.buckets[i]++
+				break
+			}
+		}
+		s.value += value
+		s.count++
+	})
+}
+
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if s, ok := h.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.count
+	}
+	return 0
+}
+
+type GaugeFunc struct {
+	desc
+	valueFunc func() float64
+}
+
+func NewGaugeFunc(name, help string, valueFunc func() float64) *GaugeFunc {
+	return &GaugeFunc{desc: desc{name: name, help: help, metricType: "gauge"}, valueFunc: valueFunc}
+}
+
+func (g *GaugeFunc) writeMetrics(w *bufio.Writer) {
+	g.writeHeader(w)
+	_, _ = fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.valueFunc()))
+}
+
+func formatLabels(labelNames, labelValues []string, extraName, extraValue string) string {
+	if len(labelNames) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(labelNames)+1)
+	for i, labelName := range labelNames {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labelName, escapeLabelValue(labelValues[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+var labelValueReplacer = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.", "topic")
+	gauge := NewGaugeVec("test_gauge", "Test\ngauge.")
+	gaugeFunc := NewGaugeFunc("test_gauge_func", "Test gauge func.", func() float64 { return 3 })
+	registry := NewRegistry()
+	registry.MustRegister(counter, gauge, gaugeFunc)
+	counter.Inc("b")
+	counter.Add(2.5, "a\"")
+	gauge.Add(-1)
+
+	buffer := bytes.Buffer{}
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatalf("Write failed: %q", err)
+	}
+
+	const expected = "# HELP test_total Test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{topic=\"a\\\"\"} 2.5\n" +
+		"test_total{topic=\"b\"} 1\n" +
+		"# HELP test_gauge Test\\ngauge.\n" +
+		"# TYPE test_gauge gauge\n" +
+		"test_gauge -1\n" +
+		"# HELP test_gauge_func Test gauge func.\n" +
+		"# TYPE test_gauge_func gauge\n" +
+		"test_gauge_func 3\n"
+	if buffer.String() != expected {
+		t.Fatalf("Unexpected metrics %q", buffer.String())
+	}
+}
+
+func TestCounterVec_DeleteLabelValue(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.", "topic", "reason")
+	counter.Inc("a", "timeout")
+	counter.Inc("a", "closed")
+	counter.Inc("b", "timeout")
+	if deleted := counter.DeleteLabelValue("topic", "a"); deleted != 2 {
+		t.Fatalf("Deleted %d series but expected 2", deleted)
+	}
+	if deleted := counter.DeleteLabelValue("other", "b"); deleted != 0 {
+		t.Fatalf("Deleted %d series of unknown label", deleted)
+	}
+	if counter.Value("a", "timeout") != 0 || counter.Value("b", "timeout") != 1 {
+		t.Fatalf("Unexpected values %v and %v", counter.Value("a", "timeout"), counter.Value("b", "timeout"))
+	}
+}
+
+func TestHistogramVec_Observe(t *testing.T) {
+	histogram := NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 0.5}, "topic")
+	registry := NewRegistry()
+	registry.MustRegister(histogram)
+	histogram.Observe(0.25, "t")
+	histogram.Observe(0.75, "t")
+	histogram.Observe(2, "t")
+
+	buffer := bytes.Buffer{}
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatalf("Write failed: %q", err)
+	}
+
+	const expected = "# HELP test_seconds Test histogram.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{topic=\"t\",le=\"0.5\"} 1\n" +
+		"test_seconds_bucket{topic=\"t\",le=\"1\"} 2\n" +
+		"test_seconds_bucket{topic=\"t\",le=\"+Inf\"} 3\n" +
+		"test_seconds_sum{topic=\"t\"} 3\n" +
+		"test_seconds_count{topic=\"t\"} 3\n"
+	if buffer.String() != expected {
+		t.Fatalf("Unexpected metrics %q", buffer.String())
+	}
+	if histogram.Count("t") != 3 {
+		t.Fatalf("Unexpected count %d", histogram.Count("t"))
+	}
+}
+
+func TestCounterVecWrongLabelCount(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.", "topic")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected panic on wrong label value count")
+		}
+	}()
+	counter.Inc()
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.MustRegister(NewGaugeFunc("test_gauge_func", "Test gauge func.", func() float64 { return 1 }))
+	recorder := httptest.NewRecorder()
+
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", recorder.Code)
+	}
+	if recorder.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("Unexpected content type %q", recorder.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,250 @@
+// Registry of topics and their settings.
+//
+// With AutoCreate policy any topic is created when it is first used and
+// declared topics only have settings. With Declared policy only declared
+// topics may be used. If the registry has a file, topics declared at run
+// time are written to it on every change, so that they survive restarts.
+// Hooks are told when a topic gets its first subscriber or loses its last
+// one.
+package registry
+
+import (
+	"encoding/json"
+	"github.com/vaidasn/infocenter/atomicfile"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy tells which topics may be used.
+type Policy int
+
+const (
+	// AutoCreate allows any topic.
+	AutoCreate Policy = iota
+	// Declared allows declared topics only.
+	Declared
+)
+
+func (p Policy) String() string {
+	if p == Declared {
+		return "declared"
+	}
+	return "autoCreate"
+}
+
+// Settings of a topic override server settings. Nil settings are not
+// overridden.
+type Settings struct {
+	// TtlSeconds is the time-to-live of messages published without one or
+	// 0 if they do not expire.
+	TtlSeconds *int `json:"ttlSeconds,omitempty"`
+	// MaxMessageSize limits message size in bytes if it is positive.
+	MaxMessageSize *int64 `json:"maxMessageSize,omitempty"`
+	// Retain tells whether retained messages are allowed.
+	Retain *bool `json:"retain,omitempty"`
+}
+
+// Topic is a declared topic.
+type Topic struct {
+	Topic    string   `json:"topic"`
+	Settings Settings `json:"settings"`
+}
+
+// Lifecycle is a change of topic subscribers told to hooks.
+type Lifecycle int
+
+const (
+	// FirstSubscriber means the topic got its first subscriber.
+	FirstSubscriber Lifecycle = iota
+	// LastUnsubscribed means the last subscriber of the topic left.
+	LastUnsubscribed
+)
+
+func (l Lifecycle) String() string {
+	if l == LastUnsubscribed {
+		return "lastUnsubscribed"
+	}
+	return "firstSubscriber"
+}
+
+// Hook is told about topic lifecycle changes. It must not block.
+type Hook func(topic string, lifecycle Lifecycle)
+
+type Registry struct {
+	policy   Policy
+	fileName string
+	mutex    sync.RWMutex
+	topics   map[string]Settings
+	// admin has topics declared by Declare or loaded, only they are
+	// persisted.
+	admin map[string]Settings
+	// persistMutex serializes changes, so that the file is written in their
+	// order without holding mutex. It also guards loaded.
+	persistMutex sync.Mutex
+	loaded       bool
+	hooks        atomic.Pointer[[]Hook]
+}
+
+// New returns registry with the policy and the declared topics. Topics
+// declared later are persisted to fileName unless it is empty, once Load
+// is called.
+func New(policy Policy, declared []string, fileName string) *Registry {
+	r := &Registry{policy: policy, fileName: fileName, topics: map[string]Settings{}, admin: map[string]Settings{}}
+	for _, topic := range declared {
+		r.topics[topic] = Settings{}
+	}
+	return r
+}
+
+// Load loads topics persisted earlier, their settings override settings of
+// the declared ones but not of topics declared since New. Those are
+// persisted then, as the file is not written before Load.
+func (r *Registry) Load() error {
+	r.persistMutex.Lock()
+	defer r.persistMutex.Unlock()
+	var topics []Topic
+	if r.fileName != "" {
+		data, err := os.ReadFile(r.fileName)
+		if err == nil {
+			err = json.Unmarshal(data, &topics)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	r.mutex.Lock()
+	declaredSinceNew := len(r.admin) != 0
+	for _, t := range topics {
+		if _, declared := r.admin[t.Topic]; !declared {
+			r.topics[t.Topic] = t.Settings
+			r.admin[t.Topic] = t.Settings
+		}
+	}
+	admin := r.adminTopics()
+	r.mutex.Unlock()
+	r.loaded = true
+	if declaredSinceNew {
+		return r.persist(admin)
+	}
+	return nil
+}
+
+func (r *Registry) Policy() Policy {
+	return r.policy
+}
+
+// Lookup returns settings of the topic and whether the topic may be used.
+func (r *Registry) Lookup(topic string) (Settings, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	settings, declared := r.topics[topic]
+	return settings, declared || r.policy == AutoCreate
+}
+
+// Declare declares the topic with the settings replacing its earlier
+// settings. The topic is not declared if persisting fails.
+func (r *Registry) Declare(topic string, settings Settings) error {
+	r.persistMutex.Lock()
+	defer r.persistMutex.Unlock()
+	r.mutex.RLock()
+	admin := r.adminTopics()
+	r.mutex.RUnlock()
+	admin[topic] = settings
+	if err := r.persist(admin); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.topics[topic] = settings
+	r.admin[topic] = settings
+	return nil
+}
+
+// Undeclare returns false if the topic is not declared.
+func (r *Registry) Undeclare(topic string) (bool, error) {
+	r.persistMutex.Lock()
+	defer r.persistMutex.Unlock()
+	r.mutex.RLock()
+	_, declared := r.topics[topic]
+	admin := r.adminTopics()
+	r.mutex.RUnlock()
+	if !declared {
+		return false, nil
+	}
+	if _, persisted := admin[topic]; persisted {
+		delete(admin, topic)
+		if err := r.persist(admin); err != nil {
+			return false, err
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.topics, topic)
+	delete(r.admin, topic)
+	return true, nil
+}
+
+// Topics returns declared topics ordered by topic name.
+func (r *Registry) Topics() []Topic {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return sortedTopics(r.topics)
+}
+
+// adminTopics returns a copy of topics to persist.
+func (r *Registry) adminTopics() map[string]Settings {
+	admin := make(map[string]Settings, len(r.admin))
+	for topic, settings := range r.admin {
+		admin[topic] = settings
+	}
+	return admin
+}
+
+func sortedTopics(settingsOfTopics map[string]Settings) []Topic {
+	topics := make([]Topic, 0, len(settingsOfTopics))
+	for topic, settings := range settingsOfTopics {
+		topics = append(topics, Topic{Topic: topic, Settings: settings})
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Topic < topics[j].Topic
+	})
+	return topics
+}
+
+// OnLifecycle adds the hook.
+func (r *Registry) OnLifecycle(hook Hook) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var hooks []Hook
+	if current := r.hooks.Load(); current != nil {
+		hooks = append(hooks, *current...)
+	}
+	hooks = append(hooks, hook)
+	r.hooks.Store(&hooks)
+}
+
+// Fire tells hooks about the lifecycle change of the topic in order of
+// their addition. It does not lock, so that it does not wait for
+// persisting.
+func (r *Registry) Fire(topic string, lifecycle Lifecycle) {
+	hooks := r.hooks.Load()
+	if hooks == nil {
+		return
+	}
+	for _, hook := range *hooks {
+		hook(topic, lifecycle)
+	}
+}
+
+// persist writes the topics unless there is no file or it is not loaded
+// yet, as another process may still own it then. Requires persistMutex.
+func (r *Registry) persist(topics map[string]Settings) error {
+	if r.fileName == "" || !r.loaded {
+		return nil
+	}
+	return atomicfile.WriteJSON(r.fileName, sortedTopics(topics))
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New(Declared, []string{"orders"}, "")
+	if _, allowed := r.Lookup("orders"); !allowed {
+		t.Fatal("Expected declared topic to be allowed")
+	}
+	if _, allowed := r.Lookup("other"); allowed {
+		t.Fatal("Expected undeclared topic not to be allowed")
+	}
+	ttlSeconds := 60
+	if err := r.Declare("other", Settings{TtlSeconds: &ttlSeconds}); err != nil {
+		t.Fatalf("Declare failed: %q", err)
+	}
+	if settings, allowed := r.Lookup("other"); !allowed || settings.TtlSeconds == nil || *settings.TtlSeconds != 60 {
+		t.Fatalf("Unexpected settings %v of declared topic", settings)
+	}
+	if topics := r.Topics(); len(topics) != 2 || topics[0].Topic != "orders" || topics[1].Topic != "other" {
+		t.Fatalf("Unexpected topics %v", topics)
+	}
+	if undeclared, _ := r.Undeclare("other"); !undeclared {
+		t.Fatal("Expected topic to be undeclared")
+	}
+	if undeclared, _ := r.Undeclare("other"); undeclared {
+		t.Fatal("Expected undeclared topic not to be undeclared again")
+	}
+
+	r = New(AutoCreate, nil, "")
+	if settings, allowed := r.Lookup("any"); !allowed || settings != (Settings{}) {
+		t.Fatalf("Unexpected settings %v of auto-created topic", settings)
+	}
+}
+
+func TestRegistryHooks(t *testing.T) {
+	r := New(AutoCreate, nil, "")
+	var fired []Lifecycle
+	r.OnLifecycle(func(topic string, lifecycle Lifecycle) {
+		if topic == "orders" {
+			fired = append(fired, lifecycle)
+		}
+	})
+	r.Fire("orders", FirstSubscriber)
+	r.Fire("orders", LastUnsubscribed)
+	if len(fired) != 2 || fired[0] != FirstSubscriber || fired[1] != LastUnsubscribed {
+		t.Fatalf("Unexpected lifecycle changes %v", fired)
+	}
+}
+
+func TestRegistryPersistence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "topics.json")
+	r := New(Declared, []string{"orders", "alerts"}, fileName)
+	if err := r.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	retain := false
+	if err := r.Declare("orders", Settings{Retain: &retain}); err != nil {
+		t.Fatalf("Declare failed: %q", err)
+	}
+	const persisted = `[{"topic":"orders","settings":{"retain":false}}]`
+	if data, err := os.ReadFile(fileName); err != nil || strings.TrimSpace(string(data)) != persisted {
+		t.Fatalf("Unexpected persisted topics %q: %v", data, err)
+	}
+
+	r = New(Declared, []string{"alerts"}, fileName)
+	if err := r.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	topics := r.Topics()
+	if len(topics) != 2 || topics[0].Topic != "alerts" || topics[1].Topic != "orders" ||
+		topics[1].Settings.Retain == nil || *topics[1].Settings.Retain {
+		t.Fatalf("Unexpected persisted topics %v", topics)
+	}
+}
+
+func TestRegistryDeclareBeforeLoad(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "topics.json")
+	r := New(Declared, nil, fileName)
+	_ = r.Load()
+	_ = r.Declare("orders", Settings{})
+	_ = r.Declare("alerts", Settings{})
+
+	r = New(Declared, nil, fileName)
+	ttlSeconds := 60
+	if err := r.Declare("orders", Settings{TtlSeconds: &ttlSeconds}); err != nil {
+		t.Fatalf("Declare failed: %q", err)
+	}
+	if data, _ := os.ReadFile(fileName); strings.Contains(string(data), "ttlSeconds") {
+		t.Fatalf("Unexpected topics %q persisted before Load", data)
+	}
+	if err := r.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	if settings, _ := r.Lookup("orders"); settings.TtlSeconds == nil || *settings.TtlSeconds != 60 {
+		t.Fatalf("Unexpected settings %v of topic declared before Load", settings)
+	}
+	r = New(Declared, nil, fileName)
+	_ = r.Load()
+	if topics := r.Topics(); len(topics) != 2 || topics[1].Settings.TtlSeconds == nil {
+		t.Fatalf("Unexpected persisted topics %v", topics)
+	}
+}
@@ -0,0 +1,272 @@
+// Scheduler of messages delivered at a later time.
+//
+// Pending messages are kept in memory and, if the scheduler has a file,
+// written to it periodically and on Stop, so that they survive restarts.
+// Messages scheduled or cancelled within persistInterval before a crash are
+// lost. Due messages are removed from the file before delivery, so a
+// message is delivered at most once even if the process stops while
+// delivering.
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/vaidasn/infocenter/atomicfile"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// persistInterval is the period of writing changed messages to the file.
+const persistInterval = time.Second
+
+// Message is a message to publish to topic at DeliverAt. Retain and
+// TtlSeconds are publishing options applied at delivery.
+type Message struct {
+	ID         string    `json:"id"`
+	Topic      string    `json:"topic"`
+	Message    string    `json:"message"`
+	DeliverAt  time.Time `json:"deliverAt"`
+	Retain     bool      `json:"retain,omitempty"`
+	TtlSeconds int       `json:"ttlSeconds,omitempty"`
+}
+
+// ErrTooManyMessages is returned by Schedule when MaxMessages messages are
+// pending.
+var ErrTooManyMessages = errors.New("too many scheduled messages")
+
+type Scheduler struct {
+	// MaxMessages limits pending messages if it is positive.
+	MaxMessages int
+	deliver     func(Message)
+	fileName    string
+	mutex       sync.Mutex
+	pending     map[string]Message
+	changed     bool
+	// loaded, started and stopped tell whether Load, Start and Stop were
+	// called, so that Stop does not wait for Start which never runs.
+	loaded   bool
+	started  bool
+	stopped  bool
+	wakeCh   chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	doneCh   chan struct{}
+}
+
+// New returns scheduler delivering messages by deliver. Messages are
+// persisted to fileName unless it is empty.
+func New(deliver func(Message), fileName string) *Scheduler {
+	return &Scheduler{
+		deliver:  deliver,
+		fileName: fileName,
+		pending:  map[string]Message{},
+		wakeCh:   make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Load adds messages persisted earlier to messages scheduled since New.
+// Those due are delivered once the scheduler starts. Load must be called
+// before Start, which writes the file, and it may be delayed until another
+// process persisting to the file stops.
+func (s *Scheduler) Load() error {
+	if s.fileName == "" {
+		return nil
+	}
+	var messages []Message
+	data, err := os.ReadFile(s.fileName)
+	if err == nil {
+		err = json.Unmarshal(data, &messages)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.loaded = true
+	for _, message := range messages {
+		s.pending[message.ID] = message
+	}
+	return nil
+}
+
+// Start delivers messages when they are due and writes changed messages to
+// the file periodically until Stop is called. It returns at once if Stop
+// was called already.
+func (s *Scheduler) Start() {
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return
+	}
+	s.started = true
+	s.mutex.Unlock()
+	defer close(s.doneCh)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	for {
+		due, next := s.takeDue(time.Now())
+		for _, message := range due {
+			s.deliver(message)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-timer.C:
+		case <-s.wakeCh:
+		case <-ticker.C:
+			s.persistChanged()
+		case <-s.stopCh:
+			s.persistChanged()
+			return
+		}
+	}
+}
+
+// Stop stops delivering, waits until a delivery in progress completes and
+// writes changed messages to the file. If Start has not been called, Stop
+// returns at once and writes the file only if Load was called, as another
+// process may still own the file otherwise. Stop may be called more than once.
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	s.stopped = true
+	loaded, started := s.loaded, s.started
+	s.mutex.Unlock()
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	if started {
+		<-s.doneCh
+	} else if loaded {
+		s.persistChanged()
+	}
+}
+
+// Schedule adds the message with a new ID and returns it.
+func (s *Scheduler) Schedule(message Message) (Message, error) {
+	id, err := newID()
+	if err != nil {
+		return message, err
+	}
+	message.ID = id
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.MaxMessages > 0 && len(s.pending) >= s.MaxMessages {
+		return message, ErrTooManyMessages
+	}
+	s.pending[id] = message
+	s.changed = true
+	s.wake()
+	return message, nil
+}
+
+// Cancel removes pending message of the ID. Returns false if there is no
+// such message.
+func (s *Scheduler) Cancel(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.pending[id]; !ok {
+		return false
+	}
+	delete(s.pending, id)
+	s.changed = true
+	s.wake()
+	return true
+}
+
+// Messages returns pending messages ordered by delivery time.
+func (s *Scheduler) Messages() []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sorted()
+}
+
+// takeDue removes and returns messages due at now and returns delivery
+// time of the next pending message or zero time if there are none.
+func (s *Scheduler) takeDue(now time.Time) (due []Message, next time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, message := range s.sorted() {
+		if message.DeliverAt.After(now) {
+			next = message.DeliverAt
+			break
+		}
+		due = append(due, message)
+		delete(s.pending, message.ID)
+	}
+	if len(due) != 0 {
+		s.changed = true
+		if err := s.persist(); err != nil {
+			// Messages are delivered anyway, they may be delivered again
+			// after restart.
+			log.Println("Persisting scheduled messages failed: ", err)
+		}
+	}
+	return due, next
+}
+
+func (s *Scheduler) sorted() []Message {
+	messages := make([]Message, 0, len(s.pending))
+	for _, message := range s.pending {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].DeliverAt.Equal(messages[j].DeliverAt) {
+			return messages[i].DeliverAt.Before(messages[j].DeliverAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	return messages
+}
+
+func (s *Scheduler) persistChanged() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.persist(); err != nil {
+		log.Println("Persisting scheduled messages failed: ", err)
+	}
+}
+
+// persist replaces the file with pending messages if they changed.
+func (s *Scheduler) persist() error {
+	if s.fileName == "" || !s.changed {
+		return nil
+	}
+	if err := atomicfile.WriteJSON(s.fileName, s.sorted()); err != nil {
+		return err
+	}
+	s.changed = false
+	return nil
+}
+
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func newID() (string, error) {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	delivered := make(chan Message, 2)
+	s := New(func(message Message) { delivered <- message }, "")
+	go s.Start()
+	defer s.Stop()
+	now := time.Now()
+	later, err := s.Schedule(Message{Topic: "test", Message: "later", DeliverAt: now.Add(200 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("Schedule failed: %q", err)
+	}
+	sooner, _ := s.Schedule(Message{Topic: "test", Message: "sooner", DeliverAt: now.Add(100 * time.Millisecond)})
+	cancelled, _ := s.Schedule(Message{Topic: "test", Message: "cancelled", DeliverAt: now.Add(time.Hour)})
+	if messages := s.Messages(); len(messages) != 3 || messages[0].ID != sooner.ID || messages[1].ID != later.ID {
+		t.Fatalf("Unexpected messages %v", messages)
+	}
+	if !s.Cancel(cancelled.ID) {
+		t.Fatal("Cancel failed")
+	}
+	if s.Cancel(cancelled.ID) {
+		t.Fatal("Cancelled message twice")
+	}
+	for _, expected := range []Message{sooner, later} {
+		select {
+		case message := <-delivered:
+			if message.ID != expected.ID || time.Now().Before(message.DeliverAt) {
+				t.Fatalf("Delivered %v but expected %v", message, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Message %v was not delivered", expected)
+		}
+	}
+	if messages := s.Messages(); len(messages) != 0 {
+		t.Fatalf("Unexpected messages %v", messages)
+	}
+}
+
+func TestSchedulerMaxMessages(t *testing.T) {
+	s := New(func(Message) {}, "")
+	s.MaxMessages = 1
+	if _, err := s.Schedule(Message{DeliverAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Schedule failed: %q", err)
+	}
+	if _, err := s.Schedule(Message{DeliverAt: time.Now().Add(time.Hour)}); err != ErrTooManyMessages {
+		t.Fatalf("Schedule returned %v but expected %v", err, ErrTooManyMessages)
+	}
+}
+
+func TestSchedulerStopWithoutStart(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "scheduled.json")
+	s := New(func(Message) {}, fileName)
+	if _, err := s.Schedule(Message{DeliverAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Schedule failed: %q", err)
+	}
+	s.Stop()
+	s.Start()
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Fatalf("Expected scheduler not started to leave the file alone but got %v", err)
+	}
+}
+
+func TestSchedulerPersistence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "scheduled.json")
+	load := func() *Scheduler {
+		t.Helper()
+		s := New(func(Message) {}, fileName)
+		if err := s.Load(); err != nil {
+			t.Fatalf("Load failed: %q", err)
+		}
+		go s.Start()
+		return s
+	}
+	s := load()
+	scheduled, err := s.Schedule(Message{Topic: "test", Message: "persisted", DeliverAt: time.Now().Add(time.Hour),
+		Retain: true, TtlSeconds: 60})
+	if err != nil {
+		t.Fatalf("Schedule failed: %q", err)
+	}
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Fatalf("Expected messages to be persisted later but got %v", err)
+	}
+	for deadline := time.Now().Add(5 * persistInterval); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(fileName); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Messages were not persisted periodically")
+		}
+	}
+	s.Stop()
+
+	s = load()
+	messages := s.Messages()
+	if len(messages) != 1 || messages[0].ID != scheduled.ID || messages[0].Message != "persisted" ||
+		!messages[0].DeliverAt.Equal(scheduled.DeliverAt) || !messages[0].Retain || messages[0].TtlSeconds != 60 {
+		t.Fatalf("Unexpected persisted messages %v", messages)
+	}
+	if !s.Cancel(scheduled.ID) {
+		t.Fatal("Cancel failed")
+	}
+	s.Stop()
+	s = load()
+	defer s.Stop()
+	if messages := s.Messages(); len(messages) != 0 {
+		t.Fatalf("Unexpected persisted messages %v", messages)
+	}
+}
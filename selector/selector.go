@@ -0,0 +1,417 @@
+// Selector expressions filtering messages delivered to subscribers.
+//
+// An expression compares fields with literals or other fields, e.g.
+// topic == "orders" && data.region == "eu" || !(data.amount < 100).
+// Fields are names separated by dots, where the first name is looked up by
+// the caller and the rest select members of JSON objects. Literals are
+// double quoted strings, numbers, true, false and null. Comparisons are
+// ==, !=, <, <=, > and >=, combined by &&, || and !. Missing fields are
+// null. Values of different types are never equal and are not ordered.
+//
+// Expressions are limited in length and nesting depth, and evaluation
+// visits every node at most once, so a selector cannot make evaluation
+// expensive.
+package selector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxLength limits expression length in bytes.
+	MaxLength = 1024
+	// MaxDepth limits nesting of parentheses and negations.
+	MaxDepth = 32
+)
+
+// Fields returns the value of the named field or false if there is none.
+// Values are nil, bool, float64, string, or map[string]interface{} and
+// []interface{} as decoded from JSON.
+type Fields func(name string) (interface{}, bool)
+
+// Selector is a parsed expression.
+type Selector struct {
+	expression string
+	root       node
+}
+
+// SyntaxError describes the invalid expression part at byte Offset.
+type SyntaxError struct {
+	Offset  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+}
+
+// Parse returns selector of the expression.
+func Parse(expression string) (*Selector, error) {
+	if len(expression) > MaxLength {
+		return nil, &SyntaxError{Offset: MaxLength, Message: fmt.Sprintf("longer than %d bytes", MaxLength)}
+	}
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, &SyntaxError{Offset: t.offset, Message: fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return &Selector{expression: expression, root: root}, nil
+}
+
+func (s *Selector) String() string {
+	return s.expression
+}
+
+// Match returns true if the expression is true for the fields.
+func (s *Selector) Match(fields Fields) bool {
+	return s.root.eval(fields) == true
+}
+
+type node interface {
+	eval(fields Fields) interface{}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n literal) eval(Fields) interface{} {
+	return n.value
+}
+
+type field struct {
+	names []string
+}
+
+func (n field) eval(fields Fields) interface{} {
+	value, ok := fields(n.names[0])
+	if !ok {
+		return nil
+	}
+	for _, name := range n.names[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(fields Fields) interface{} {
+	return n.operand.eval(fields) != true
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n logical) eval(fields Fields) interface{} {
+	left := n.left.eval(fields) == true
+	if left != n.and {
+		return left
+	}
+	return n.right.eval(fields) == true
+}
+
+type comparison struct {
+	operator    string
+	left, right node
+}
+
+func (n comparison) eval(fields Fields) interface{} {
+	left, right := n.left.eval(fields), n.right.eval(fields)
+	switch n.operator {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+	var order int
+	switch left := left.(type) {
+	case float64:
+		right, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		order = compareFloats(left, right)
+	case string:
+		right, ok := right.(string)
+		if !ok {
+			return false
+		}
+		order = strings.Compare(left, right)
+	default:
+		return false
+	}
+	switch n.operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
+
+// equal compares scalar values. Objects and arrays are equal to nothing.
+func equal(left, right interface{}) bool {
+	switch left.(type) {
+	case nil, bool, float64, string:
+		return left == right
+	}
+	return false
+}
+
+func compareFloats(left, right float64) int {
+	if left < right {
+		return -1
+	} else if left > right {
+		return 1
+	}
+	return 0
+}
+
+type parser struct {
+	tokens   []token
+	position int
+	depth    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.position]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.position]
+	if t.kind != tokenEnd {
+		p.position++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if t.kind != tokenOperator || t.text != "!" {
+		return p.parseComparison()
+	}
+	p.next()
+	if err := p.enter(t); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return not{operand}, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return left, nil
+	}
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return comparison{operator: t.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return literal{t.value}, nil
+	case tokenNumber:
+		return literal{t.value}, nil
+	case tokenField:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		return field{strings.Split(t.text, ".")}, nil
+	case tokenOperator:
+		if t.text != "(" {
+			break
+		}
+		if err := p.enter(t); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.text != ")" {
+			return nil, &SyntaxError{Offset: closing.offset, Message: "expected )"}
+		}
+		return inner, nil
+	case tokenEnd:
+		return nil, &SyntaxError{Offset: t.offset, Message: "unexpected end"}
+	}
+	return nil, &SyntaxError{Offset: t.offset, Message: fmt.Sprintf("unexpected %q", t.text)}
+}
+
+func (p *parser) enter(t token) error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return &SyntaxError{Offset: t.offset, Message: fmt.Sprintf("nested deeper than %d", MaxDepth)}
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenOperator
+	tokenField
+	tokenString
+	tokenNumber
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	value  interface{}
+	offset int
+}
+
+// operators are ordered so that longer ones match first.
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expression) && expression[end] != '"'; end++ {
+				if expression[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expression) {
+				return nil, &SyntaxError{Offset: i, Message: "unterminated string"}
+			}
+			value, err := strconv.Unquote(expression[i : end+1])
+			if err != nil {
+				return nil, &SyntaxError{Offset: i, Message: "invalid string"}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: expression[i : end+1], value: value, offset: i})
+			i = end + 1
+		case isDigit(c) || c == '-' && i+1 < len(expression) && isDigit(expression[i+1]):
+			end := i + 1
+			for end < len(expression) && (isDigit(expression[end]) || strings.IndexByte(".eE", expression[end]) >= 0 ||
+				strings.IndexByte("+-", expression[end]) >= 0 && strings.IndexByte("eE", expression[end-1]) >= 0) {
+				end++
+			}
+			value, err := strconv.ParseFloat(expression[i:end], 64)
+			if err != nil {
+				return nil, &SyntaxError{Offset: i, Message: "invalid number"}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expression[i:end], value: value, offset: i})
+			i = end
+		case isNameStart(c):
+			end := i + 1
+			for end < len(expression) && (isNameStart(expression[end]) || isDigit(expression[end]) ||
+				expression[end] == '.' && end+1 < len(expression) && isNameStart(expression[end+1])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenField, text: expression[i:end], offset: i})
+			i = end
+		default:
+			operator := ""
+			for _, o := range operators {
+				if strings.HasPrefix(expression[i:], o) {
+					operator = o
+					break
+				}
+			}
+			if operator == "" {
+				return nil, &SyntaxError{Offset: i, Message: fmt.Sprintf("unexpected %q", c)}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, offset: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, offset: len(expression)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
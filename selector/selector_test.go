@@ -0,0 +1,73 @@
+package selector
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testFields(t *testing.T) Fields {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"region":"eu","amount":150,"urgent":true,"customer":{"tier":"gold"}}`),
+		&data); err != nil {
+		t.Fatalf("Decoding data failed: %q", err)
+	}
+	return func(name string) (interface{}, bool) {
+		switch name {
+		case "topic":
+			return "orders", true
+		case "data":
+			return data, true
+		}
+		return nil, false
+	}
+}
+
+func TestMatch(t *testing.T) {
+	fields := testFields(t)
+	for expression, expected := range map[string]bool{
+		`data.region == "eu"`:                                    true,
+		`data.region != "eu"`:                                    false,
+		`data.amount > 100 && data.amount <= 150`:                true,
+		`data.amount < 100 || topic == "orders"`:                 true,
+		`!(data.amount >= 1e3)`:                                  true,
+		`data.urgent`:                                            true,
+		`!data.urgent`:                                           false,
+		`data.customer.tier == "gold"`:                           true,
+		`data.customer == "gold"`:                                false,
+		`data.missing == null && data.missing.x == null`:         true,
+		`data.region > 100`:                                      false,
+		`data.region < "fr" && "eu" == data.region`:              true,
+		`data.amount == -150 || data.amount == 150.0`:            true,
+		`other == "orders"`:                                      false,
+		`data.region == "eu" && data.customer.tier != "silver"`:  true,
+		`topic == "orders" && (data.amount < 10 || data.urgent)`: true,
+	} {
+		s, err := Parse(expression)
+		if err != nil {
+			t.Fatalf("Parsing %s failed: %q", expression, err)
+		}
+		if actual := s.Match(fields); actual != expected {
+			t.Errorf("%s was %v but expected %v", expression, actual, expected)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for expression, expected := range map[string]string{
+		``:                                "unexpected end at offset 0",
+		`data.region ==`:                  "unexpected end at offset 14",
+		`data.region = "eu"`:              `unexpected '=' at offset 12`,
+		`(topic == "orders"`:              "expected ) at offset 18",
+		`topic == "orders")`:              `unexpected ")" at offset 17`,
+		`topic == "orders`:                "unterminated string at offset 9",
+		`topic == 1.2.3`:                  "invalid number at offset 9",
+		`topic topic`:                     `unexpected "topic" at offset 6`,
+		strings.Repeat("!", 33) + "topic": "nested deeper than 32 at offset 32",
+		strings.Repeat("a", MaxLength+1):  "longer than 1024 bytes at offset 1024",
+	} {
+		if _, err := Parse(expression); err == nil || err.Error() != expected {
+			t.Errorf("Parsing %.20s returned error %v but expected %s", expression, err, expected)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/registry"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// subscribeAck returns true if the event stream request asks for messages
+// to be acknowledged. Only consumer group members may acknowledge them.
+func subscribeAck(request *http.Request, writer http.ResponseWriter) (ack bool, ok bool) {
+	value := request.URL.Query().Get("ack")
+	if value == "" {
+		return false, true
+	}
+	ack, err := strconv.ParseBool(value)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Invalid ack parameter")
+		return false, false
+	}
+	if ack && request.URL.Query().Get("group") == "" {
+		writeError(writer, http.StatusBadRequest, "Acknowledged delivery requires group parameter")
+		return false, false
+	}
+	return ack, true
+}
+
+type infocenterAckHandler struct {
+	eventStreamBroker *chanbroker.Broker
+	topicRegistry     *registry.Registry
+}
+
+func newInfocenterAckHandler(eventStreamBroker *chanbroker.Broker,
+	topicRegistry *registry.Registry) *infocenterAckHandler {
+	return &infocenterAckHandler{eventStreamBroker: eventStreamBroker, topicRegistry: topicRegistry}
+}
+
+// ServeHTTP acknowledges the message delivered with the id, so that it is
+// not delivered again.
+func (handler *infocenterAckHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	if _, ok := topicSettings(handler.topicRegistry, writer, topic); !ok {
+		return
+	}
+	if !handler.eventStreamBroker.Ack(topic, mux.Vars(request)["id"]) {
+		if handler.eventStreamBroker.ShuttingDown() {
+			writer.Header().Set("Retry-After", strconv.Itoa(currentSettings().shutdownRetrySeconds))
+			writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+			return
+		}
+		writeError(writer, http.StatusNotFound, "Message is not in flight")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// writeDeliveryEvent writes message event of a message to be acknowledged. The
+// event id is the delivery id to acknowledge starting with the attempt.
+func writeDeliveryEvent(w io.Writer, delivery chanbroker.Delivery, event string, message string,
+	expires time.Time) error {
+	if err := validateEvent(event, message); err != nil {
+		return err
+	}
+	if err := writeTtlComment(w, expires); err != nil {
+		return err
+	}
+	return writeEventWithId(w, delivery.ID, event, message)
+}
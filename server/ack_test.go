@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bufio"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent returns lines of the next event of the stream.
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading event stream failed: %q", err)
+		}
+		if line == "\n" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func postAck(t *testing.T, url string) int {
+	t.Helper()
+	response, err := http.DefaultClient.Post(url, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	_ = response.Body.Close()
+	return response.StatusCode
+}
+
+func TestAckRedelivery(t *testing.T) {
+	defer setAdminToken("secret")()
+	savedBrokerOptions := BrokerOptions
+	BrokerOptions.AckTimeout = time.Second
+	defer func() { BrokerOptions = savedBrokerOptions }()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+
+	if response, err := http.DefaultClient.Get(baseUrl + "/infocenter/ack-test?ack=true"); err != nil {
+		t.Fatal("GET failed")
+	} else if _ = response.Body.Close(); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Response code of ack without group was %d but expected %d", response.StatusCode,
+			http.StatusBadRequest)
+	}
+
+	subscription, err := http.DefaultClient.Get(baseUrl + "/infocenter/ack-test?group=workers&ack=true")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	defer subscription.Body.Close()
+	reader := bufio.NewReader(subscription.Body)
+	waitSubscribers(t, baseUrl, "ack-test", 1)
+	response, err := http.DefaultClient.Post(baseUrl+"/infocenter/ack-test", "text/plain", nil)
+	if err != nil {
+		t.Fatal("POST failed")
+	}
+	_ = response.Body.Close()
+
+	event := readEvent(t, reader)
+	if len(event) != 3 || !strings.HasPrefix(event[0], "id: 1-") || event[1] != "event: msg\n" {
+		t.Fatalf("Unexpected event %q", event)
+	}
+	redelivery := readEvent(t, reader)
+	if len(redelivery) != 3 || !strings.HasPrefix(redelivery[0], "id: 2-") {
+		t.Fatalf("Unexpected redelivery %q of %q", redelivery, event)
+	}
+	staleAckUrl := baseUrl + "/infocenter/ack-test/ack/" + strings.TrimSpace(event[0][len("id: "):])
+	if statusCode := postAck(t, staleAckUrl); statusCode != http.StatusNotFound {
+		t.Fatalf("Response code of stale ack was %d but expected %d", statusCode, http.StatusNotFound)
+	}
+	ackUrl := baseUrl + "/infocenter/ack-test/ack/" + strings.TrimSpace(redelivery[0][len("id: "):])
+	if statusCode := postAck(t, ackUrl); statusCode != http.StatusNoContent {
+		t.Fatalf("Response code of ack was %d but expected %d", statusCode, http.StatusNoContent)
+	}
+	if statusCode := postAck(t, ackUrl); statusCode != http.StatusNotFound {
+		t.Fatalf("Response code of repeated ack was %d but expected %d", statusCode, http.StatusNotFound)
+	}
+	stopServing(t, server, doneServing)
+}
+
+func TestAckShutdown(t *testing.T) {
+	eventStreamBroker := newEventStreamBroker()
+	ackHandler := newInfocenterAckHandler(eventStreamBroker, nil)
+	eventStreamBroker.Shutdown()
+	defer eventStreamBroker.Stop()
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/infocenter/ack-test/ack/1-ID", http.NoBody)
+	request = mux.SetURLVars(request, map[string]string{"topic": "ack-test", "id": "1-ID"})
+
+	ackHandler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Response code was %d but expected %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After header is missing")
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/scheduler"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminToken is the bearer token required by admin endpoints unless client
+// identity is listed in AdminIdentities. Admin endpoints are disabled when
+// both are empty.
+var AdminToken = ""
+
+type adminTopic struct {
+	Topic       string     `json:"topic"`
+	Subscribers int        `json:"subscribers"`
+	Messages    uint64     `json:"messages"`
+	MessageRate float64    `json:"messageRate"`
+	LastMessage *time.Time `json:"lastMessageTime,omitempty"`
+	Closed      bool       `json:"closed"`
+	Retained    bool       `json:"retained"`
+	// Groups maps consumer group names to their member counts.
+	Groups map[string]int `json:"groups,omitempty"`
+}
+
+func newAdminTopic(info chanbroker.TopicInfo) adminTopic {
+	topic := adminTopic{
+		Topic:       info.Topic,
+		Subscribers: info.Subscribers,
+		Messages:    info.Messages,
+		MessageRate: info.MessageRate,
+		Closed:      info.Closed,
+		Retained:    info.Retained,
+		Groups:      info.Groups,
+	}
+	if !info.LastMessage.IsZero() {
+		lastMessage := info.LastMessage.UTC()
+		topic.LastMessage = &lastMessage
+	}
+	return topic
+}
+
+type adminAuthHandler struct {
+	handler http.Handler
+}
+
+func newAdminAuthHandler(handler http.Handler) *adminAuthHandler {
+	return &adminAuthHandler{handler: handler}
+}
+
+func (handler *adminAuthHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	settings := currentSettings()
+	if settings.adminToken == "" && settings.adminIdentities == "" {
+		writeError(writer, http.StatusForbidden, "Admin API is disabled")
+		return
+	}
+	if settings.adminIdentities != "" && adminIdentity(request, settings.adminIdentities) {
+		handler.handler.ServeHTTP(writer, request)
+		return
+	}
+	token, bearer := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if settings.adminToken == "" || !bearer ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(settings.adminToken)) != 1 {
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="infocenter admin"`)
+		writeError(writer, http.StatusUnauthorized, "Invalid admin credentials")
+		return
+	}
+	handler.handler.ServeHTTP(writer, request)
+}
+
+type adminTopicsHandler struct {
+	eventStreamBroker *chanbroker.Broker
+}
+
+func newAdminTopicsHandler(eventStreamBroker *chanbroker.Broker) *adminTopicsHandler {
+	return &adminTopicsHandler{eventStreamBroker: eventStreamBroker}
+}
+
+func (handler *adminTopicsHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	infos := handler.eventStreamBroker.Topics()
+	topics := make([]adminTopic, len(infos))
+	for i, info := range infos {
+		topics[i] = newAdminTopic(info)
+	}
+	writeJson(writer, http.StatusOK, topics)
+}
+
+type adminTopicHandler struct {
+	eventStreamBroker *chanbroker.Broker
+}
+
+func newAdminTopicHandler(eventStreamBroker *chanbroker.Broker) *adminTopicHandler {
+	return &adminTopicHandler{eventStreamBroker: eventStreamBroker}
+}
+
+func (handler *adminTopicHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	info, ok := handler.eventStreamBroker.Topic(topic)
+	if !ok {
+		writeError(writer, http.StatusNotFound, "Topic not found")
+		return
+	}
+	writeJson(writer, http.StatusOK, newAdminTopic(info))
+}
+
+type adminTopicActionResult struct {
+	Topic        string `json:"topic"`
+	Disconnected int    `json:"disconnected"`
+}
+
+type adminTopicActionHandler struct {
+	action func(topic string) int
+}
+
+func newAdminTopicActionHandler(action func(topic string) int) *adminTopicActionHandler {
+	return &adminTopicActionHandler{action: action}
+}
+
+func (handler *adminTopicActionHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	disconnected := handler.action(topic)
+	writeJson(writer, http.StatusOK, adminTopicActionResult{Topic: topic, Disconnected: disconnected})
+}
+
+type adminClearRetainedHandler struct {
+	eventStreamBroker *chanbroker.Broker
+}
+
+func (handler adminClearRetainedHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	if !handler.eventStreamBroker.ClearRetained(topic) {
+		writeError(writer, http.StatusNotFound, "Retained message not found")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+type adminScheduledHandler struct {
+	messageScheduler *scheduler.Scheduler
+}
+
+// ServeHTTP lists messages pending delayed delivery ordered by delivery
+// time, only those of the topic if topic parameter is given.
+func (handler adminScheduledHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic := request.URL.Query().Get("topic")
+	messages := []scheduler.Message{}
+	for _, message := range handler.messageScheduler.Messages() {
+		if topic == "" || message.Topic == topic {
+			messages = append(messages, message)
+		}
+	}
+	writeJson(writer, http.StatusOK, messages)
+}
+
+type adminCancelScheduledHandler struct {
+	messageScheduler *scheduler.Scheduler
+}
+
+func (handler adminCancelScheduledHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !handler.messageScheduler.Cancel(mux.Vars(request)["id"]) {
+		writeError(writer, http.StatusNotFound, "Scheduled message not found")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+type adminConfigReloadHandler struct{}
+
+func (handler adminConfigReloadHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	if ConfigLoader == nil {
+		writeError(writer, http.StatusNotImplemented, "Configuration reload is not available")
+		return
+	}
+	result, err := Reload()
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	logReloadResult(result)
+	writeJson(writer, http.StatusOK, result)
+}
+
+func writeJson(writer http.ResponseWriter, statusCode int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.Println("Writing response failed: ", err)
+	}
+}
+
+func writeError(writer http.ResponseWriter, statusCode int, message string) {
+	writer.WriteHeader(statusCode)
+	if _, err := writer.Write([]byte(message)); err != nil {
+		log.Println("Writing response failed: ", err)
+	}
+}
+
+func configAdminRoutes(r *mux.Router, services *services) {
+	eventStreamBroker, messageScheduler := services.eventStreamBroker, services.messageScheduler
+	r.Handle("/admin/topics",
+		newAdminAuthHandler(newAdminTopicsHandler(eventStreamBroker))).Methods(http.MethodGet)
+	r.Handle("/admin/topics/{topic}",
+		newAdminAuthHandler(newAdminTopicHandler(eventStreamBroker))).Methods(http.MethodGet)
+	r.Handle("/admin/topics/{topic}/kick",
+		newAdminAuthHandler(newAdminTopicActionHandler(eventStreamBroker.KickSubscribers))).Methods(http.MethodPost)
+	r.Handle("/admin/topics/{topic}/close",
+		newAdminAuthHandler(newAdminTopicActionHandler(eventStreamBroker.CloseTopic))).Methods(http.MethodPost)
+	r.Handle("/admin/topics/{topic}/open",
+		newAdminAuthHandler(newAdminTopicActionHandler(func(topic string) int {
+			eventStreamBroker.OpenTopic(topic)
+			return 0
+		}))).Methods(http.MethodPost)
+	r.Handle("/admin/topics/{topic}/retained",
+		newAdminAuthHandler(adminClearRetainedHandler{eventStreamBroker})).Methods(http.MethodDelete)
+	r.Handle("/admin/scheduled",
+		newAdminAuthHandler(adminScheduledHandler{messageScheduler})).Methods(http.MethodGet)
+	r.Handle("/admin/scheduled/{id}",
+		newAdminAuthHandler(adminCancelScheduledHandler{messageScheduler})).Methods(http.MethodDelete)
+	r.Handle("/admin/registry",
+		newAdminAuthHandler(adminRegistryHandler{services.topicRegistry})).Methods(http.MethodGet)
+	r.Handle("/admin/registry/{topic}",
+		newAdminAuthHandler(adminDeclareTopicHandler{services.topicRegistry})).Methods(http.MethodPut)
+	r.Handle("/admin/registry/{topic}",
+		newAdminAuthHandler(adminUndeclareTopicHandler{services.topicRegistry})).Methods(http.MethodDelete)
+	r.Handle("/admin/webhooks",
+		newAdminAuthHandler(adminWebhooksHandler{services.webhookDispatcher})).Methods(http.MethodGet)
+	r.Handle("/admin/webhooks",
+		newAdminAuthHandler(adminAddWebhookHandler{services.webhookDispatcher})).Methods(http.MethodPost)
+	r.Handle("/admin/webhooks/dead-letters",
+		newAdminAuthHandler(adminWebhookDeadLettersHandler{services.webhookDispatcher})).Methods(http.MethodGet)
+	r.Handle("/admin/webhooks/{id}",
+		newAdminAuthHandler(adminRemoveWebhookHandler{services.webhookDispatcher})).Methods(http.MethodDelete)
+	r.Handle("/admin/config/reload",
+		newAdminAuthHandler(adminConfigReloadHandler{})).Methods(http.MethodPost)
+}
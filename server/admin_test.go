@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func adminRequest(t *testing.T, method string, url string, token string) *http.Response {
+	return clientAdminRequest(t, http.DefaultClient, method, url, token)
+}
+
+func clientAdminRequest(t *testing.T, client *http.Client, method string, url string, token string) *http.Response {
+	request, err := http.NewRequest(method, url, http.NoBody)
+	if err != nil {
+		t.Fatalf("Got error while creating new request: %q", err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("%s failed: %q", method, err)
+	}
+	return response
+}
+
+func setAdminToken(token string) (restore func()) {
+	savedAdminToken := AdminToken
+	AdminToken = token
+	return func() {
+		AdminToken = savedAdminToken
+	}
+}
+
+func TestAdminTopics(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	postUrl := fmt.Sprintf("http://%s/infocenter/admin-test", l.Addr().String())
+	if _, err := http.DefaultClient.Post(postUrl, "text/plain", bytes.NewBufferString("test message")); err != nil {
+		t.Fatal("POST failed")
+	}
+
+	response := adminRequest(t, http.MethodGet, fmt.Sprintf("http://%s/admin/topics", l.Addr().String()), "secret")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	var topics []adminTopic
+	if err := json.NewDecoder(response.Body).Decode(&topics); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	if len(topics) != 1 || topics[0].Topic != "admin-test" || topics[0].Messages != 1 ||
+		topics[0].LastMessage == nil {
+		t.Fatalf("Unexpected topics %v", topics)
+	}
+
+	response = adminRequest(t, http.MethodGet,
+		fmt.Sprintf("http://%s/admin/topics/admin-test", l.Addr().String()), "secret")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	response = adminRequest(t, http.MethodGet,
+		fmt.Sprintf("http://%s/admin/topics/unknown", l.Addr().String()), "secret")
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNotFound)
+	}
+
+	stopServing(t, server, doneServing)
+}
+
+func TestAdminUnauthorized(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+
+	response := adminRequest(t, http.MethodGet, fmt.Sprintf("http://%s/admin/topics", l.Addr().String()), "wrong")
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusUnauthorized)
+	}
+	request, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/admin/topics", l.Addr().String()), nil)
+	request.Header.Set("Authorization", "secret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GET failed: %q", err)
+	}
+	if _ = response.Body.Close(); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Response code of token without Bearer scheme was %d but expected %d", response.StatusCode,
+			http.StatusUnauthorized)
+	}
+
+	stopServing(t, server, doneServing)
+}
+
+func TestAdminDisabled(t *testing.T) {
+	defer setAdminToken("")()
+	l, server, doneServing := listenAndServe(t)
+
+	response := adminRequest(t, http.MethodGet, fmt.Sprintf("http://%s/admin/topics", l.Addr().String()), "")
+	if response.StatusCode != http.StatusForbidden {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusForbidden)
+	}
+
+	stopServing(t, server, doneServing)
+}
+
+func TestAdminCloseTopic(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	topicUrl := fmt.Sprintf("http://%s/infocenter/closed-test", l.Addr().String())
+	adminTopicUrl := fmt.Sprintf("http://%s/admin/topics/closed-test", l.Addr().String())
+
+	response := adminRequest(t, http.MethodPost, adminTopicUrl+"/close", "secret")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	response, err := http.DefaultClient.Post(topicUrl, "text/plain", bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatal("POST failed")
+	}
+	if response.StatusCode != http.StatusForbidden {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusForbidden)
+	}
+	response, err = http.DefaultClient.Get(topicUrl)
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	bodyBuffer := bytes.Buffer{}
+	if _, err := bodyBuffer.ReadFrom(response.Body); err != nil {
+		t.Fatalf("Read body failed: %q", err)
+	}
+	if bodyBuffer.String() != "id: 1\nevent: closed\ndata: closed\n\n" {
+		t.Fatalf("Unrecognized response content %q", bodyBuffer.String())
+	}
+
+	adminRequest(t, http.MethodPost, adminTopicUrl+"/open", "secret")
+	response, err = http.DefaultClient.Post(topicUrl, "text/plain", bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatal("POST failed")
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+
+	stopServing(t, server, doneServing)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/config"
+	"github.com/vaidasn/infocenter/registry"
+	"github.com/vaidasn/infocenter/webhook"
+	"log"
+	"sync"
+	"time"
+)
+
+// ConfigLoader loads configuration when reloading. Reloading is not
+// available if it is nil.
+var ConfigLoader func() (config.Config, error)
+
+// liveSettings can be changed by Reload while the server is running.
+// They are guarded by settingsMutex.
+var liveSettings = map[string]struct{}{
+	"server.eventStreamTimeoutSeconds": {},
+	"server.shutdownTimeoutSeconds":    {},
+	"server.shutdownDelaySeconds":      {},
+	"server.shutdownRetrySeconds":      {},
+	"auth.adminToken":                  {},
+	"auth.adminIdentities":             {},
+	"limits.maxMessageSize":            {},
+	"cors.allowedOrigins":              {},
+	"cors.allowCredentials":            {},
+	"cors.allowedHeaders":              {},
+	"cors.maxAgeSeconds":               {},
+	"messages.defaultTtlSeconds":       {},
+	"messages.topicTtlSeconds":         {},
+	"messages.requestTimeoutSeconds":   {},
+}
+
+var (
+	settingsMutex sync.RWMutex
+	appliedConfig = config.Default()
+)
+
+// settings is a snapshot of live settings.
+type settings struct {
+	eventStreamTimeoutSeconds int
+	shutdownTimeoutSeconds    int
+	shutdownDelaySeconds      int
+	shutdownRetrySeconds      int
+	adminToken                string
+	adminIdentities           string
+	maxMessageSize            int64
+	corsAllowedOrigins        []string
+	corsAllowCredentials      bool
+	corsAllowedHeaders        []string
+	corsMaxAgeSeconds         int
+	defaultTtlSeconds         int
+	topicTtlSeconds           map[string]int
+	requestTimeoutSeconds     int
+}
+
+func currentSettings() settings {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return settings{
+		eventStreamTimeoutSeconds: EventStreamTimeoutSeconds,
+		shutdownTimeoutSeconds:    ShutdownTimeoutSeconds,
+		shutdownDelaySeconds:      ShutdownDelaySeconds,
+		shutdownRetrySeconds:      ShutdownRetrySeconds,
+		adminToken:                AdminToken,
+		adminIdentities:           AdminIdentities,
+		maxMessageSize:            MaxMessageSize,
+		corsAllowedOrigins:        CORSAllowedOrigins,
+		corsAllowCredentials:      CORSAllowCredentials,
+		corsAllowedHeaders:        CORSAllowedHeaders,
+		corsMaxAgeSeconds:         CORSMaxAgeSeconds,
+		defaultTtlSeconds:         DefaultTtlSeconds,
+		topicTtlSeconds:           TopicTtlSeconds,
+		requestTimeoutSeconds:     RequestTimeoutSeconds,
+	}
+}
+
+// Configure applies the configuration to package settings. It must be
+// invoked before creating a server.
+func Configure(c config.Config) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	ListenAddresses = config.List(c.Server.Listen)
+	AdminListenAddresses = config.List(c.Server.AdminListen)
+	RoutesPrefix = c.Server.RoutesPrefix
+	H2C = c.Server.H2C
+	BrokerOptions = chanbroker.Options{
+		EventQueueSize:       c.Broker.EventQueueSize,
+		SubscriberBufferSize: c.Broker.SubscriberBufferSize,
+		GroupBalancing:       chanbroker.RoundRobin,
+		AckTimeout:           time.Duration(c.Broker.AckTimeoutSeconds) * time.Second,
+		MaxDeliveryAttempts:  c.Broker.MaxDeliveryAttempts,
+		DeadLetterSuffix:     c.Broker.DeadLetterSuffix,
+	}
+	if c.Broker.GroupBalancing == "leastLoaded" {
+		BrokerOptions.GroupBalancing = chanbroker.LeastLoaded
+	}
+	TLSCertFile = c.TLS.CertFile
+	TLSKeyFile = c.TLS.KeyFile
+	TLSClientCAFile = c.TLS.ClientCAFile
+	TLSClientAuth = c.TLS.ClientAuth
+	PersistenceDirectory = c.Persistence.Directory
+	TopicPolicy = registry.AutoCreate
+	if c.Topics.Policy == "declared" {
+		TopicPolicy = registry.Declared
+	}
+	DeclaredTopics = config.List(c.Topics.Declared)
+	LifecycleTopic = c.Topics.LifecycleTopic
+	WebhookOptions = webhook.Options{
+		MaxAttempts:    c.Webhooks.MaxAttempts,
+		InitialBackoff: time.Duration(c.Webhooks.InitialBackoffSeconds) * time.Second,
+		MaxBackoff:     time.Duration(c.Webhooks.MaxBackoffSeconds) * time.Second,
+		Timeout:        time.Duration(c.Webhooks.TimeoutSeconds) * time.Second,
+		QueueSize:      c.Webhooks.QueueSize,
+	}
+	InboundSourcesFile = c.Inbound.SourcesFile
+	MaxScheduledMessages = c.Limits.MaxScheduledMessages
+	MaxIdempotencyKeys = c.Limits.MaxIdempotencyKeys
+	IdempotencyWindowSeconds = c.Messages.IdempotencyWindowSeconds
+	applyLiveSettings(c)
+	appliedConfig = c
+}
+
+func applyLiveSettings(c config.Config) {
+	EventStreamTimeoutSeconds = c.Server.EventStreamTimeoutSeconds
+	ShutdownTimeoutSeconds = c.Server.ShutdownTimeoutSeconds
+	ShutdownDelaySeconds = c.Server.ShutdownDelaySeconds
+	ShutdownRetrySeconds = c.Server.ShutdownRetrySeconds
+	AdminToken = c.Auth.AdminToken
+	AdminIdentities = c.Auth.AdminIdentities
+	MaxMessageSize = c.Limits.MaxMessageSize
+	CORSAllowedOrigins = config.List(c.CORS.AllowedOrigins)
+	CORSAllowCredentials = c.CORS.AllowCredentials
+	CORSAllowedHeaders = config.List(c.CORS.AllowedHeaders)
+	CORSMaxAgeSeconds = c.CORS.MaxAgeSeconds
+	DefaultTtlSeconds = c.Messages.DefaultTtlSeconds
+	TopicTtlSeconds, _ = config.IntMap(c.Messages.TopicTtlSeconds)
+	RequestTimeoutSeconds = c.Messages.RequestTimeoutSeconds
+}
+
+// ReloadResult lists changed settings as dotted paths.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// Reload loads configuration by ConfigLoader and applies changed live
+// settings at once. Other changed settings are reported as requiring
+// restart and are left unchanged. Nothing is applied if loading fails.
+func Reload() (ReloadResult, error) {
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	c, err := ConfigLoader()
+	if err != nil {
+		return result, err
+	}
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	for _, setting := range config.Diff(appliedConfig, c) {
+		if _, live := liveSettings[setting]; live {
+			result.Applied = append(result.Applied, setting)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, setting)
+		}
+	}
+	applyLiveSettings(c)
+	for setting := range liveSettings {
+		if err := appliedConfig.Copy(c, setting); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func reloadOnSignal() {
+	if ConfigLoader == nil {
+		log.Println("Ignoring SIGHUP signal, configuration reload is not available")
+		return
+	}
+	result, err := Reload()
+	if err != nil {
+		log.Println("Configuration reload failed: ", err)
+		return
+	}
+	logReloadResult(result)
+}
+
+func logReloadResult(result ReloadResult) {
+	log.Printf("Configuration reloaded, applied settings %v, settings requiring restart %v",
+		result.Applied, result.RestartRequired)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vaidasn/infocenter/config"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func setConfigLoader(configLoader func() (config.Config, error)) (restore func()) {
+	savedConfigLoader := ConfigLoader
+	ConfigLoader = configLoader
+	Configure(config.Default())
+	return func() {
+		ConfigLoader = savedConfigLoader
+		Configure(config.Default())
+	}
+}
+
+func TestReload(t *testing.T) {
+	reloaded := config.Default()
+	reloaded.Server.EventStreamTimeoutSeconds = 10
+	reloaded.Server.Port = 9090
+	reloaded.Broker.EventQueueSize = 8
+	defer setConfigLoader(func() (config.Config, error) {
+		return reloaded, nil
+	})()
+
+	result, err := Reload()
+
+	if err != nil {
+		t.Fatalf("Reload failed: %q", err)
+	}
+	if !reflect.DeepEqual(result.Applied, []string{"server.eventStreamTimeoutSeconds"}) {
+		t.Fatalf("Unexpected applied settings %q", result.Applied)
+	}
+	if !reflect.DeepEqual(result.RestartRequired, []string{"server.port", "broker.eventQueueSize"}) {
+		t.Fatalf("Unexpected settings requiring restart %q", result.RestartRequired)
+	}
+	if currentSettings().eventStreamTimeoutSeconds != 10 {
+		t.Fatalf("Unexpected event stream timeout %d", currentSettings().eventStreamTimeoutSeconds)
+	}
+	if BrokerOptions.EventQueueSize != 1 {
+		t.Fatalf("Unexpected event queue size %d", BrokerOptions.EventQueueSize)
+	}
+
+	result, err = Reload()
+
+	if err != nil {
+		t.Fatalf("Reload failed: %q", err)
+	}
+	if len(result.Applied) != 0 || len(result.RestartRequired) != 2 {
+		t.Fatalf("Unexpected reload result %v", result)
+	}
+}
+
+func TestFailedReload(t *testing.T) {
+	defer setConfigLoader(func() (config.Config, error) {
+		return config.Default(), errors.New("invalid configuration")
+	})()
+
+	if _, err := Reload(); err == nil || err.Error() != "invalid configuration" {
+		t.Fatalf("Unexpected error %q", err)
+	}
+}
+
+func TestAdminConfigReload(t *testing.T) {
+	reloaded := config.Default()
+	reloaded.Auth.AdminToken = "new-secret"
+	defer setConfigLoader(func() (config.Config, error) {
+		return reloaded, nil
+	})()
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	reloadUrl := fmt.Sprintf("http://%s/admin/config/reload", l.Addr().String())
+
+	response := adminRequest(t, http.MethodPost, reloadUrl, "secret")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	var result ReloadResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	if !reflect.DeepEqual(result, ReloadResult{Applied: []string{"auth.adminToken"}, RestartRequired: []string{}}) {
+		t.Fatalf("Unexpected reload result %v", result)
+	}
+	response = adminRequest(t, http.MethodPost, reloadUrl, "secret")
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusUnauthorized)
+	}
+
+	stopServing(t, server, doneServing)
+}
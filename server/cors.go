@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS settings allow browsers on other origins to subscribe and publish.
+// CORS is disabled if CORSAllowedOrigins is empty. Origin * allows any
+// origin.
+var (
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials = false
+	CORSAllowedHeaders   = []string{"Content-Type", idempotencyKeyHeader, "Prefer"}
+	CORSMaxAgeSeconds    = 600
+)
+
+// corsMethods are methods allowed in preflight requests.
+var corsMethods = []string{http.MethodGet, http.MethodPost}
+
+// corsExposedHeaders are response headers scripts of allowed origins may
+// read.
+var corsExposedHeaders = []string{"Retry-After", messageIdHeader, "Idempotent-Replayed", "Preference-Applied",
+	correlationIdHeader}
+
+// corsMiddleware adds CORS headers to responses to allowed origins.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		settings := currentSettings()
+		if len(settings.corsAllowedOrigins) != 0 {
+			// Responses differ by origin, so caches must not share them even
+			// when the request had no origin.
+			writer.Header().Add("Vary", "Origin")
+		}
+		if origin := request.Header.Get("Origin"); origin != "" {
+			if allowOrigin, ok := corsAllowOrigin(origin, settings); ok {
+				writer.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+				writer.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+				if settings.corsAllowCredentials {
+					writer.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// corsAllowOrigin returns Access-Control-Allow-Origin header value if the
+// origin is allowed. Origin is returned instead of * if credentials are
+// allowed as browsers require.
+func corsAllowOrigin(origin string, settings settings) (allowOrigin string, ok bool) {
+	for _, allowedOrigin := range settings.corsAllowedOrigins {
+		if allowedOrigin == "*" && !settings.corsAllowCredentials {
+			return "*", true
+		}
+		if allowedOrigin == "*" || strings.EqualFold(allowedOrigin, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// corsPreflightHandler answers preflight requests of allowed origins
+// asking for allowed methods and headers. Access-Control-Allow-Origin is set
+// by corsMiddleware. It is routed for topic paths only, so that OPTIONS
+// requests of unknown paths are not found.
+type corsPreflightHandler struct{}
+
+func (handler corsPreflightHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	origin := request.Header.Get("Origin")
+	method := request.Header.Get("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		writer.Header().Set("Allow", strings.Join(append(corsMethods, http.MethodOptions), ", "))
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	settings := currentSettings()
+	if _, ok := corsAllowOrigin(origin, settings); !ok {
+		writeError(writer, http.StatusForbidden, "Origin is not allowed")
+		return
+	}
+	if !contains(corsMethods, method) {
+		writeError(writer, http.StatusForbidden, "Method is not allowed")
+		return
+	}
+	for _, requestHeader := range strings.Split(request.Header.Get("Access-Control-Request-Headers"), ",") {
+		requestHeader = strings.TrimSpace(requestHeader)
+		if requestHeader != "" && !containsFold(settings.corsAllowedHeaders, requestHeader) {
+			writeError(writer, http.StatusForbidden, "Header "+requestHeader+" is not allowed")
+			return
+		}
+	}
+	writer.Header().Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
+	if len(settings.corsAllowedHeaders) != 0 {
+		writer.Header().Set("Access-Control-Allow-Headers", strings.Join(settings.corsAllowedHeaders, ", "))
+	}
+	writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(settings.corsMaxAgeSeconds))
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
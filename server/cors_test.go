@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func setCORS(origins []string, allowCredentials bool) (restore func()) {
+	savedOrigins, savedAllowCredentials := CORSAllowedOrigins, CORSAllowCredentials
+	CORSAllowedOrigins, CORSAllowCredentials = origins, allowCredentials
+	return func() {
+		CORSAllowedOrigins, CORSAllowCredentials = savedOrigins, savedAllowCredentials
+	}
+}
+
+func corsRequest(t *testing.T, method string, url string, header http.Header) *http.Response {
+	request, err := http.NewRequest(method, url, bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatalf("Got error while creating new request: %q", err)
+	}
+	request.Header = header
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s failed: %q", method, err)
+	}
+	_ = response.Body.Close()
+	return response
+}
+
+func assertHeader(t *testing.T, response *http.Response, name string, expected string) {
+	t.Helper()
+	if value := response.Header.Get(name); value != expected {
+		t.Fatalf("Header %s was %q but expected %q", name, value, expected)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	defer setCORS([]string{"https://app.example.com"}, true)()
+	l, server, doneServing := listenAndServe(t)
+	postUrl := fmt.Sprintf("http://%s/infocenter/cors-test", l.Addr().String())
+
+	response := corsRequest(t, http.MethodPost, postUrl, http.Header{"Origin": {"https://app.example.com"}})
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	assertHeader(t, response, "Access-Control-Allow-Origin", "https://app.example.com")
+	assertHeader(t, response, "Access-Control-Allow-Credentials", "true")
+	assertHeader(t, response, "Vary", "Origin")
+	assertHeader(t, response, "Access-Control-Expose-Headers",
+		"Retry-After, Message-Id, Idempotent-Replayed, Preference-Applied, Correlation-Id")
+
+	response = corsRequest(t, http.MethodPost, postUrl, http.Header{"Origin": {"https://other.example.com"}})
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	assertHeader(t, response, "Access-Control-Allow-Origin", "")
+
+	response = corsRequest(t, http.MethodOptions, postUrl, http.Header{
+		"Origin":                         {"https://app.example.com"},
+		"Access-Control-Request-Method":  {http.MethodPost},
+		"Access-Control-Request-Headers": {"content-type, idempotency-key, prefer"},
+	})
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Preflight response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	assertHeader(t, response, "Access-Control-Allow-Origin", "https://app.example.com")
+	assertHeader(t, response, "Access-Control-Allow-Methods", "GET, POST")
+	assertHeader(t, response, "Access-Control-Allow-Headers", "Content-Type, Idempotency-Key, Prefer")
+	assertHeader(t, response, "Access-Control-Max-Age", "600")
+
+	for _, header := range []http.Header{
+		{"Origin": {"https://other.example.com"}, "Access-Control-Request-Method": {http.MethodPost}},
+		{"Origin": {"https://app.example.com"}, "Access-Control-Request-Method": {http.MethodDelete}},
+		{"Origin": {"https://app.example.com"}, "Access-Control-Request-Method": {http.MethodPost},
+			"Access-Control-Request-Headers": {"X-Custom"}},
+	} {
+		response = corsRequest(t, http.MethodOptions, postUrl, header)
+		if response.StatusCode != http.StatusForbidden {
+			t.Fatalf("Preflight response code was %d but expected %d for %v", response.StatusCode,
+				http.StatusForbidden, header)
+		}
+	}
+	stopServing(t, server, doneServing)
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	defer setCORS([]string{"*"}, false)()
+	l, server, doneServing := listenAndServe(t)
+	healthzUrl := fmt.Sprintf("http://%s/healthz", l.Addr().String())
+
+	response := corsRequest(t, http.MethodGet, healthzUrl, http.Header{"Origin": {"https://any.example.com"}})
+	assertHeader(t, response, "Access-Control-Allow-Origin", "*")
+	assertHeader(t, response, "Access-Control-Allow-Credentials", "")
+	response = corsRequest(t, http.MethodGet, healthzUrl, http.Header{})
+	assertHeader(t, response, "Vary", "Origin")
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		response = corsRequest(t, method, fmt.Sprintf("http://%s/unknown", l.Addr().String()),
+			http.Header{"Origin": {"https://any.example.com"}, "Access-Control-Request-Method": {http.MethodGet}})
+		if response.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s response code was %d but expected %d", method, response.StatusCode, http.StatusNotFound)
+		}
+	}
+	stopServing(t, server, doneServing)
+}
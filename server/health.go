@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"github.com/vaidasn/infocenter/chanbroker"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// readinessCheckTimeout limits duration of a single readiness check.
+const readinessCheckTimeout = 2 * time.Second
+
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+type healthzHandler struct{}
+
+func (handler healthzHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write([]byte("ok\n")); err != nil {
+		log.Println("Writing response failed: ", err)
+	}
+}
+
+type readyzHandler struct {
+	checks   []readinessCheck
+	draining atomic.Bool
+}
+
+func newReadyzHandler(eventStreamBroker *chanbroker.Broker) *readyzHandler {
+	handler := &readyzHandler{}
+	handler.checks = []readinessCheck{
+		{name: "shutdown", check: func() error {
+			if handler.draining.Load() || eventStreamBroker.ShuttingDown() {
+				return fmt.Errorf("shutting down")
+			}
+			return nil
+		}},
+		{name: "broker", check: func() error {
+			if !eventStreamBroker.Ping(readinessCheckTimeout) {
+				return fmt.Errorf("not responding within %s", readinessCheckTimeout)
+			}
+			return nil
+		}},
+	}
+	if PersistenceDirectory != "" {
+		directory := PersistenceDirectory
+		handler.checks = append(handler.checks, readinessCheck{name: "persistence", check: func() error {
+			return checkWritableDirectory(directory)
+		}})
+	}
+	return handler
+}
+
+func checkWritableDirectory(directory string) error {
+	file, err := os.CreateTemp(directory, ".readyz-")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+// startDraining makes readiness fail so that traffic gets routed elsewhere
+// before the server shuts down.
+func (handler *readyzHandler) startDraining() {
+	handler.draining.Store(true)
+}
+
+func (handler *readyzHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	ready := true
+	report := ""
+	for _, check := range handler.checks {
+		if err := check.check(); err != nil {
+			ready = false
+			report += fmt.Sprintf("%s: %s\n", check.name, err)
+		} else {
+			report += fmt.Sprintf("%s: ok\n", check.name)
+		}
+	}
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if ready {
+		writer.WriteHeader(http.StatusOK)
+	} else {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, err := writer.Write([]byte(report)); err != nil {
+		log.Println("Writing response failed: ", err)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	l, server, doneServing := listenAndServe(t)
+	response, err := http.DefaultClient.Get(fmt.Sprintf("http://%s/healthz", l.Addr().String()))
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	stopServing(t, server, doneServing)
+}
+
+func TestReadyz(t *testing.T) {
+	l, server, doneServing := listenAndServe(t)
+	response, err := http.DefaultClient.Get(fmt.Sprintf("http://%s/readyz", l.Addr().String()))
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	bodyBuffer := bytes.Buffer{}
+	if _, err := bodyBuffer.ReadFrom(response.Body); err != nil {
+		t.Fatalf("Read body failed: %q", err)
+	}
+	if bodyBuffer.String() != "shutdown: ok\nbroker: ok\n" {
+		t.Fatalf("Unrecognized response content %q", bodyBuffer.String())
+	}
+	stopServing(t, server, doneServing)
+}
+
+func TestReadyzDraining(t *testing.T) {
+	eventStreamBroker := newEventStreamBroker()
+	defer eventStreamBroker.Stop()
+	readyzHandler := newReadyzHandler(eventStreamBroker)
+	readyzHandler.startDraining()
+	recorder := httptest.NewRecorder()
+
+	readyzHandler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", http.NoBody))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Response code was %d but expected %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if recorder.Body.String() != "shutdown: shutting down\nbroker: ok\n" {
+		t.Fatalf("Unrecognized response content %q", recorder.Body.String())
+	}
+}
+
+func TestReadyzShutdown(t *testing.T) {
+	eventStreamBroker := newEventStreamBroker()
+	readyzHandler := newReadyzHandler(eventStreamBroker)
+	eventStreamBroker.Shutdown()
+	eventStreamBroker.Stop()
+	recorder := httptest.NewRecorder()
+
+	readyzHandler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", http.NoBody))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Response code was %d but expected %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+}
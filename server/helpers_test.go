@@ -1,11 +1,15 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"fmt"
 	"github.com/gorilla/mux"
 	"net"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,6 +27,24 @@ func listenAndServe(t *testing.T) (net.Listener, *http.Server, chan error) {
 	return l, server, doneServing
 }
 
+func waitSubscribers(t *testing.T, baseUrl string, topic string, subscribers int) {
+	waitClientSubscribers(t, http.DefaultClient, baseUrl, topic, subscribers)
+}
+
+func waitClientSubscribers(t *testing.T, client *http.Client, baseUrl string, topic string, subscribers int) {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		response := clientAdminRequest(t, client, http.MethodGet, baseUrl+"/admin/topics/"+topic, "secret")
+		bodyBuffer := bytes.Buffer{}
+		_, _ = bodyBuffer.ReadFrom(response.Body)
+		_ = response.Body.Close()
+		if response.StatusCode == http.StatusOK &&
+			strings.Contains(bodyBuffer.String(), fmt.Sprintf(`"subscribers":%d,`, subscribers)) {
+			return
+		}
+	}
+	t.Fatalf("Topic %s did not get %d subscribers", topic, subscribers)
+}
+
 func subscribeTestEvent(t *testing.T, infocenterPostHandler *infocenterPostHandler, enableTimeout bool) (quitCh chan int) {
 	const subscribeTimeout = 2 * time.Second
 	quitCh = make(chan int)
@@ -34,6 +56,9 @@ func subscribeTestEvent(t *testing.T, infocenterPostHandler *infocenterPostHandl
 		for {
 			select {
 			case chVal := <-subscribeCh:
+				if published, ok := chVal.(publishedMessage); ok {
+					chVal = published.topicAndMessage
+				}
 				expectedMessage := topicAndMessage{"test-topic", "message text"}
 				if chVal != expectedMessage {
 					t.Errorf("Channel value %q", chVal)
@@ -99,12 +124,15 @@ func assertResponseHeaders(t *testing.T, writer testGetResponseWriter) {
 	}
 }
 
+// stopServing shuts the server down and stops its services, so that no
+// handler is left running.
 func stopServing(t *testing.T, server *http.Server, doneServing chan error) {
 	_ = server.Shutdown(context.Background())
 	serveError := <-doneServing
 	if serveError != http.ErrServerClosed {
 		t.Fatalf("Server failed with error %q", serveError)
 	}
+	server.Handler.(countingHandler).services.stop()
 }
 
 func bytesOfBytes(strings ...string) [][]byte {
@@ -114,3 +142,51 @@ func bytesOfBytes(strings ...string) [][]byte {
 	}
 	return bytesOfBytes
 }
+
+// serverSentEvent is an event as parsed by EventSource of browsers with
+// comments EventSource ignores.
+type serverSentEvent struct {
+	id       string
+	event    string
+	data     string
+	comments []string
+}
+
+// readServerSentEvent parses the next event of the stream the way
+// EventSource does. Unknown fields are ignored and data fields are joined
+// by newline.
+func readServerSentEvent(t *testing.T, reader *bufio.Reader) serverSentEvent {
+	t.Helper()
+	var event serverSentEvent
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading event stream failed: %q", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if data == nil {
+				continue
+			}
+			event.data = strings.Join(data, "\n")
+			return event
+		}
+		if strings.HasPrefix(line, ":") {
+			event.comments = append(event.comments, strings.TrimPrefix(line[1:], " "))
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveCountingConnections serves server on a new listener counting
+// accepted connections.
+func serveCountingConnections(t *testing.T, server *http.Server, useTLS bool) (net.Listener, *atomic.Int32, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("net.Listen failed")
+	}
+	connections := new(atomic.Int32)
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	doneServing := make(chan error)
+	go func() {
+		if useTLS {
+			doneServing <- server.ServeTLS(l, "", "")
+		} else {
+			doneServing <- server.Serve(l)
+		}
+	}()
+	return l, connections, doneServing
+}
+
+// assertMultiplexedStreams opens event streams of several topics over the
+// client and checks they all receive messages posted to their topics.
+func assertMultiplexedStreams(t *testing.T, client *http.Client, baseUrl string) {
+	topics := []string{"h2-first", "h2-second", "h2-third"}
+	readers := make([]*bufio.Reader, len(topics))
+	for i, topic := range topics {
+		response, err := client.Get(baseUrl + "/infocenter/" + topic)
+		if err != nil {
+			t.Fatalf("GET failed: %q", err)
+		}
+		defer response.Body.Close()
+		if response.ProtoMajor != 2 {
+			t.Fatalf("Response protocol was %s but expected HTTP/2", response.Proto)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+		}
+		readers[i] = bufio.NewReader(response.Body)
+	}
+	for i, topic := range topics {
+		waitClientSubscribers(t, client, baseUrl, topic, 1)
+		response, err := client.Post(baseUrl+"/infocenter/"+topic, "text/plain",
+			bytes.NewBufferString("message "+topic))
+		if err != nil {
+			t.Fatalf("POST failed: %q", err)
+		}
+		_ = response.Body.Close()
+		event := ""
+		for !strings.HasSuffix(event, "\n\n") {
+			line, err := readers[i].ReadString('\n')
+			if err != nil {
+				t.Fatalf("Reading event failed: %q", err)
+			}
+			event += line
+		}
+		if expected := "event: msg\ndata: message " + topic + "\n\n"; !strings.HasSuffix(event, expected) {
+			t.Fatalf("Unexpected event %q, expected %q", event, expected)
+		}
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	defer setAdminToken("secret")()
+	ca := newTestCertificate(t, "test-ca", 1, nil)
+	defer setTLS(t, ca, newTestCertificate(t, "server", 2, ca), "none")()
+	server := NewServer()
+	var err error
+	if server.TLSConfig, err = newTLSConfig(); err != nil {
+		t.Fatalf("Creating TLS configuration failed: %q", err)
+	}
+	l, connections, doneServing := serveCountingConnections(t, server, true)
+	client, _ := newTLSClient(ca, nil)
+	transport := client.Transport.(*http.Transport)
+	transport.DisableKeepAlives = false
+	transport.ForceAttemptHTTP2 = true
+
+	assertMultiplexedStreams(t, client, "https://"+l.Addr().String())
+	if n := connections.Load(); n != 1 {
+		t.Fatalf("Client made %d connections but expected 1", n)
+	}
+	transport.CloseIdleConnections()
+	stopServing(t, server, doneServing)
+}
+
+func TestH2C(t *testing.T) {
+	defer setAdminToken("secret")()
+	savedH2C := H2C
+	H2C = true
+	defer func() { H2C = savedH2C }()
+	server := NewServer()
+	l, connections, doneServing := serveCountingConnections(t, server, false)
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: protocols}
+	client := &http.Client{Transport: transport}
+
+	assertMultiplexedStreams(t, client, "http://"+l.Addr().String())
+	if n := connections.Load(); n != 1 {
+		t.Fatalf("Client made %d connections but expected 1", n)
+	}
+	transport.CloseIdleConnections()
+	stopServing(t, server, doneServing)
+}
+
+func TestH2CDisabled(t *testing.T) {
+	server := NewServer()
+	l, _, doneServing := serveCountingConnections(t, server, false)
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}, Timeout: 2 * time.Second}
+
+	if _, err := client.Get("http://" + l.Addr().String() + "/healthz"); err == nil {
+		t.Fatal("Expected h2c request to fail when h2c is disabled")
+	}
+	stopServing(t, server, doneServing)
+}
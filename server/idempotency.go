@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/vaidasn/infocenter/idempotency"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	messageIdHeader      = "Message-Id"
+	// idempotencyFileName is the file in PersistenceDirectory keeping
+	// remembered idempotency keys.
+	idempotencyFileName = "idempotency.json"
+	// maxIdempotencyKeyLength limits idempotency key length in bytes.
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyWindowSeconds is the time responses to posts with idempotency
+// keys are remembered for. MaxIdempotencyKeys limits remembered keys if it
+// is positive.
+var (
+	IdempotencyWindowSeconds = 86400
+	MaxIdempotencyKeys       = 100000
+)
+
+// newIdempotencyStore returns store of idempotency keys persisted if
+// PersistenceDirectory is set. The store loads persisted keys and starts
+// once an upgrading process hands over.
+func newIdempotencyStore() *idempotency.Store {
+	fileName := ""
+	if PersistenceDirectory != "" {
+		fileName = filepath.Join(PersistenceDirectory, idempotencyFileName)
+	}
+	store := idempotency.New(time.Duration(IdempotencyWindowSeconds)*time.Second, MaxIdempotencyKeys, fileName)
+	go func() {
+		<-upgradeHandedOver()
+		if err := store.Load(); err != nil {
+			log.Fatal("Loading idempotency keys failed: ", err)
+		}
+		store.Start()
+	}()
+	return store
+}
+
+// newMessageId returns random id of a posted message.
+func newMessageId() string {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		log.Println("Generating message id failed: ", err)
+		return ""
+	}
+	return hex.EncodeToString(buffer)
+}
+
+// recordingResponseWriter keeps status code and body written, so that the
+// response may be replayed.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// response returns the recorded response.
+func (w *recordingResponseWriter) response() idempotency.Response {
+	return idempotency.Response{
+		MessageID:   w.Header().Get(messageIdHeader),
+		StatusCode:  w.statusCode,
+		ContentType: w.Header().Get("Content-Type"),
+		Body:        w.body.Bytes(),
+	}
+}
+
+// writeReplayed writes the response remembered for the idempotency key.
+func writeReplayed(writer http.ResponseWriter, response idempotency.Response) {
+	if response.MessageID != "" {
+		writer.Header().Set(messageIdHeader, response.MessageID)
+	}
+	if response.ContentType != "" {
+		writer.Header().Set("Content-Type", response.ContentType)
+	}
+	writer.Header().Set("Idempotent-Replayed", "true")
+	writer.WriteHeader(response.StatusCode)
+	if len(response.Body) == 0 {
+		return
+	}
+	if _, err := writer.Write(response.Body); err != nil {
+		log.Println("Writing response failed: ", err)
+	}
+}
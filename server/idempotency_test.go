@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func postIdempotent(t *testing.T, url string, key string) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatalf("Got error while creating new request: %q", err)
+	}
+	request.Header.Set(idempotencyKeyHeader, key)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	_ = response.Body.Close()
+	return response
+}
+
+func TestIdempotentPost(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	topicUrl := func(topic string) string {
+		return fmt.Sprintf("http://%s/infocenter/%s", l.Addr().String(), topic)
+	}
+
+	first := postIdempotent(t, topicUrl("idempotency-test"), "key-1")
+	if first.StatusCode != http.StatusNoContent || first.Header.Get(messageIdHeader) == "" {
+		t.Fatalf("Unexpected response %d with message id %q", first.StatusCode, first.Header.Get(messageIdHeader))
+	}
+	duplicate := postIdempotent(t, topicUrl("idempotency-test"), "key-1")
+	if duplicate.StatusCode != http.StatusNoContent ||
+		duplicate.Header.Get(messageIdHeader) != first.Header.Get(messageIdHeader) ||
+		duplicate.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Unexpected duplicate response %d with headers %v", duplicate.StatusCode, duplicate.Header)
+	}
+	other := postIdempotent(t, topicUrl("other-idempotency-test"), "key-1")
+	if other.Header.Get(messageIdHeader) == first.Header.Get(messageIdHeader) {
+		t.Fatal("Idempotency key was not scoped by topic")
+	}
+
+	scheduled := postIdempotent(t, topicUrl("idempotency-test")+"?delay=3600", "key-2")
+	duplicate = postIdempotent(t, topicUrl("idempotency-test")+"?delay=3600", "key-2")
+	if scheduled.StatusCode != http.StatusAccepted || duplicate.StatusCode != http.StatusAccepted ||
+		duplicate.Header.Get(messageIdHeader) != scheduled.Header.Get(messageIdHeader) {
+		t.Fatalf("Unexpected duplicate response %d with headers %v", duplicate.StatusCode, duplicate.Header)
+	}
+	if messages := adminScheduled(t, fmt.Sprintf("http://%s/admin/scheduled", l.Addr().String())); len(messages) != 1 {
+		t.Fatalf("Unexpected scheduled messages %v", messages)
+	}
+
+	response := adminRequest(t, http.MethodGet,
+		fmt.Sprintf("http://%s/admin/topics/idempotency-test", l.Addr().String()), "secret")
+	var topic adminTopic
+	if err := json.NewDecoder(response.Body).Decode(&topic); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	if topic.Messages != 1 {
+		t.Fatalf("Duplicate was published, topic %v", topic)
+	}
+	stopServing(t, server, doneServing)
+}
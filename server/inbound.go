@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/inbound"
+	"github.com/vaidasn/infocenter/registry"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxInboundBodySize limits inbound webhook body size in bytes, also when
+// MaxMessageSize does not limit messages.
+const maxInboundBodySize = 1 << 20
+
+// InboundSourcesFile is JSON file of inbound webhook sources. Inbound
+// webhooks are not accepted if it is empty.
+var InboundSourcesFile = ""
+
+// reservedEvents are event types of the server which inbound messages may
+// not have.
+var reservedEvents = map[string]struct{}{
+	"closed":   {},
+	"join":     {},
+	"leave":    {},
+	"request":  {},
+	"shutdown": {},
+	"timeout":  {},
+}
+
+// newInboundAdapter returns adapter of sources in InboundSourcesFile or nil
+// if it is not set.
+func newInboundAdapter() *inbound.Adapter {
+	if InboundSourcesFile == "" {
+		return nil
+	}
+	sources, err := inbound.Load(InboundSourcesFile)
+	if err != nil {
+		log.Fatal("Loading inbound webhook sources failed: ", err)
+	}
+	adapter, err := inbound.New(sources)
+	if err != nil {
+		log.Fatal("Loading inbound webhook sources failed: ", err)
+	}
+	return adapter
+}
+
+type inboundWebhookHandler struct {
+	eventStreamBroker *chanbroker.Broker
+	inboundAdapter    *inbound.Adapter
+	topicRegistry     *registry.Registry
+}
+
+func newInboundWebhookHandler(eventStreamBroker *chanbroker.Broker, inboundAdapter *inbound.Adapter,
+	topicRegistry *registry.Registry) inboundWebhookHandler {
+	return inboundWebhookHandler{
+		eventStreamBroker: eventStreamBroker,
+		inboundAdapter:    inboundAdapter,
+		topicRegistry:     topicRegistry,
+	}
+}
+
+// ServeHTTP verifies signature of the webhook posted by the source and
+// publishes the message it is mapped to. Unknown sources are rejected
+// before reading the body.
+func (handler inboundWebhookHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	source := mux.Vars(request)["source"]
+	if handler.inboundAdapter == nil || !handler.inboundAdapter.Has(source) {
+		writeError(writer, http.StatusNotFound, "Unknown inbound source")
+		return
+	}
+	limit := int64(maxInboundBodySize)
+	if maxMessageSize := currentSettings().maxMessageSize; maxMessageSize > 0 && maxMessageSize < limit {
+		limit = maxMessageSize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, limit))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			writeError(writer, http.StatusRequestEntityTooLarge, "Message is too large")
+			return
+		}
+		writeError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	m, err := handler.inboundAdapter.Map(source, request.Header, body, time.Now())
+	switch {
+	case errors.Is(err, inbound.ErrUnknownSource):
+		writeError(writer, http.StatusNotFound, "Unknown inbound source")
+		return
+	case errors.Is(err, inbound.ErrInvalidSignature):
+		writeError(writer, http.StatusUnauthorized, "Invalid signature")
+		return
+	case err != nil:
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, reserved := reservedEvents[m.Event]; reserved {
+		writeError(writer, http.StatusBadRequest, "Reserved event type "+m.Event)
+		return
+	}
+	settings, ok := topicSettings(handler.topicRegistry, writer, m.Topic)
+	if !ok {
+		return
+	}
+	message := legalMessage(m.Message)
+	if limit := maxMessageSize(settings); limit > 0 && int64(len(message)) > limit {
+		writeError(writer, http.StatusRequestEntityTooLarge, "Message is too large")
+		return
+	}
+	ttlSeconds, ok := messageTtlSeconds(request, writer, m.Topic, settings)
+	if !ok {
+		return
+	}
+	if handler.eventStreamBroker.TopicClosed(m.Topic) {
+		writeError(writer, http.StatusForbidden, "Topic is closed")
+		return
+	}
+	if !handler.eventStreamBroker.Publish(newTopicEventMessage(m.Topic, m.Event, message, ttlSeconds)) {
+		writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// newTopicEventMessage returns message to publish with event type unless
+// it is empty.
+func newTopicEventMessage(topic string, event string, message string, ttlSeconds int) publishedMessage {
+	published := newTopicMessage(topic, message, false, ttlSeconds)
+	published.event = event
+	return published
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hubSignature returns signature of the body by the secret as GitHub sends
+// it.
+func hubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postInbound(t *testing.T, url string, signature string, event string, body string) int {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Got error while creating new request: %q", err)
+	}
+	request.Header.Set("X-Hub-Signature-256", signature)
+	request.Header.Set("X-GitHub-Event", event)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	_ = response.Body.Close()
+	return response.StatusCode
+}
+
+func TestInboundWebhooks(t *testing.T) {
+	defer setAdminToken("secret")()
+	sourcesFile := filepath.Join(t.TempDir(), "sources.json")
+	if err := os.WriteFile(sourcesFile, []byte(`[{"name":"github","secret":"github-secret",`+
+		`"signatureHeader":"X-Hub-Signature-256","signaturePrefix":"sha256=",`+
+		`"topic":"inbound-{{.Payload.repository}}","event":"{{.Header \"X-GitHub-Event\"}}"}]`), 0600); err != nil {
+		t.Fatalf("Writing sources failed: %q", err)
+	}
+	savedInboundSourcesFile := InboundSourcesFile
+	InboundSourcesFile = sourcesFile
+	defer func() { InboundSourcesFile = savedInboundSourcesFile }()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+
+	response, err := http.DefaultClient.Get(baseUrl + "/infocenter/inbound-test")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	defer response.Body.Close()
+	waitSubscribers(t, baseUrl, "inbound-test", 1)
+	body := `{"repository":"test"}`
+	signature := hubSignature("github-secret", []byte(body))
+	for _, test := range []struct {
+		source             string
+		signature          string
+		event              string
+		body               string
+		expectedStatusCode int
+	}{
+		{"other", signature, "push", body, http.StatusNotFound},
+		{"github", hubSignature("other", []byte(body)), "push", body, http.StatusUnauthorized},
+		{"github", hubSignature("github-secret", []byte("{}")), "push", "{}", http.StatusBadRequest},
+		{"github", signature, "closed", body, http.StatusBadRequest},
+		{"github", signature, "push", strings.Repeat(" ", maxInboundBodySize) + body,
+			http.StatusRequestEntityTooLarge},
+		{"github", signature, "push", body, http.StatusNoContent},
+	} {
+		if statusCode := postInbound(t, baseUrl+"/inbound/"+test.source, test.signature, test.event,
+			test.body); statusCode != test.expectedStatusCode {
+			t.Fatalf("Response code of %s %q was %d but expected %d", test.source, test.body, statusCode,
+				test.expectedStatusCode)
+		}
+	}
+	if event := readEvent(t, bufio.NewReader(response.Body)); len(event) != 3 || event[1] != "event: push\n" ||
+		event[2] != "data: "+body+"\n" {
+		t.Fatalf("Unexpected event %q", event)
+	}
+	stopServing(t, server, doneServing)
+}
@@ -137,3 +137,20 @@ func TestTwoMessageTimeoutInfocenterGetHandler_ServeHTTP(t *testing.T) {
 		t.Fatalf("Unexpected write invocations %q", writer.e.writeInvocations)
 	}
 }
+
+func TestKickedInfocenterGetHandler_ServeHTTP(t *testing.T) {
+	infocenterGetHandler, writer, _, request := mockGetRequestHandler(t, "get-topic")
+	publishTestEvent(&infocenterGetHandler, func(defaultPublishFunc func()) {
+		defaultPublishFunc()
+		infocenterGetHandler.eventStreamBroker.KickSubscribers("get-topic")
+	})
+
+	infocenterGetHandler.ServeHTTP(writer, request)
+
+	assertResponseHeaders(t, writer)
+	if !reflect.DeepEqual(writer.e.writeInvocations,
+		bytesOfBytes("id: 1\n", "event: msg\n", "data: message text\n", "\n",
+			"id: 2\n", "event: closed\n", "data: kicked\n", "\n")) {
+		t.Fatalf("Unexpected write invocations %q", writer.e.writeInvocations)
+	}
+}
@@ -22,8 +22,7 @@ type testPostResponseWriter struct {
 }
 
 func (w testPostResponseWriter) Header() http.Header {
-	w.t.Fatal("Unexpected Header invocation")
-	return nil
+	return http.Header{}
 }
 
 func (w testPostResponseWriter) Write(bytes []byte) (int, error) {
@@ -178,3 +177,29 @@ func TestMultilineInfocenterPostHandler_ServeHTTP(t *testing.T) {
 		t.Fatalf("Unexpected write invocations %q", writer.e.writeInvocations)
 	}
 }
+
+func TestShutdownInfocenterPostHandler_ServeHTTP(t *testing.T) {
+	infocenterPostHandler := infocenterPostHandler{eventStreamBroker: newEventStreamBroker()}
+	defer infocenterPostHandler.eventStreamBroker.Stop()
+	infocenterPostHandler.eventStreamBroker.Shutdown()
+	writer := testPostResponseWriter{
+		t:                  t,
+		expectedStatusCode: http.StatusServiceUnavailable,
+		e:                  &testPostResponseWriterExpectations{},
+	}
+	request, err := http.NewRequestWithContext(context.Background(), "POST",
+		"http://localhost/infocenter/test-topic", bytes.NewBufferString("message text"))
+	request = mux.SetURLVars(request, map[string]string{"topic": "test-topic"})
+	if err != nil {
+		t.Fatalf("Got error while creating new request: %q", err)
+	}
+
+	infocenterPostHandler.ServeHTTP(writer, request)
+
+	if writer.e.writeHeaderInvocations != 1 {
+		t.Fatalf("Unexpected write header invocation count %d", writer.e.writeHeaderInvocations)
+	}
+	if !reflect.DeepEqual(writer.e.writeInvocations, bytesOfBytes("Server is shutting down")) {
+		t.Fatalf("Unexpected write invocations %q", writer.e.writeInvocations)
+	}
+}
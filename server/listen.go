@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// unixAddressPrefix marks listen address as Unix domain socket path.
+const unixAddressPrefix = "unix:"
+
+// ListenAddresses are addresses to serve on instead of all interfaces at
+// the port given to ListenAndServe. Address is host:port or unix:path of
+// Unix domain socket.
+var ListenAddresses []string
+
+// AdminListenAddresses are addresses to serve admin API, metrics and
+// publishing on. Only event streams and health checks are served on
+// ListenAddresses if there are any admin listen addresses.
+var AdminListenAddresses []string
+
+// serverListeners are listeners of public server and of admin server.
+type serverListeners struct {
+	public []net.Listener
+	admin  []net.Listener
+}
+
+// openListeners returns inherited listeners or listens on configured
+// addresses of the role if none of the role are inherited.
+func openListeners(port uint16) (serverListeners, error) {
+	listeners, err := inheritListeners(os.Getenv, listenFdsStart)
+	if err != nil {
+		return listeners, err
+	}
+	if len(listeners.public) == 0 {
+		addresses := ListenAddresses
+		if len(addresses) == 0 {
+			addresses = []string{fmt.Sprintf(":%d", port)}
+		}
+		if listeners.public, err = listenAll(addresses); err != nil {
+			return listeners, err
+		}
+	} else {
+		log.Printf("Inherited %d listeners", len(listeners.public))
+	}
+	if len(listeners.admin) == 0 {
+		if listeners.admin, err = listenAll(AdminListenAddresses); err != nil {
+			return listeners, err
+		}
+	} else {
+		log.Printf("Inherited %d admin listeners", len(listeners.admin))
+	}
+	return listeners, nil
+}
+
+func listenAll(addresses []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		l, err := listen(address)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		log.Printf("Listening on %s", address)
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listen listens on TCP or Unix domain socket address. Socket file left by
+// a server that was not shut down is replaced.
+func listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixAddressPrefix) {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, unixAddressPrefix)
+	if fileInfo, err := os.Stat(path); err == nil && fileInfo.Mode()&os.ModeSocket != 0 {
+		if connection, err := net.Dial("unix", path); err == nil {
+			_ = connection.Close()
+		} else if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// serveAll serves on every listener reporting failures to doneServing.
+func serveAll(server *http.Server, listeners []net.Listener, doneServing chan<- error) {
+	for _, l := range listeners {
+		go serve(server, l, doneServing)
+	}
+}
+
+// serve serves on the listener with TLS if server has TLS configuration.
+// Unix domain sockets are served without TLS as they are local.
+func serve(server *http.Server, l net.Listener, doneServing chan<- error) {
+	if _, unix := l.(*net.UnixListener); unix || server.TLSConfig == nil {
+		doneServing <- server.Serve(l)
+	} else {
+		doneServing <- server.ServeTLS(l, "", "")
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func unixSocketClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "infocenter.sock")
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listening on stale socket failed: %q", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	l, err := listen(unixAddressPrefix + socketPath)
+	if err != nil {
+		t.Fatalf("Listening on socket failed: %q", err)
+	}
+	if _, err := listen(unixAddressPrefix + socketPath); err == nil {
+		t.Fatal("Expected listening on socket in use to fail")
+	}
+	server := NewServer()
+	doneServing := make(chan error, 1)
+	go serve(server, l, doneServing)
+	response, err := unixSocketClient(socketPath).Post("http://infocenter/infocenter/unix-test", "text/plain",
+		bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	stopServing(t, server, doneServing)
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Fatalf("Socket file was not removed: %v", err)
+	}
+}
+
+func TestSeparateAdminListener(t *testing.T) {
+	defer setAdminToken("secret")()
+	server, adminServer, _ := newServers(true)
+	l, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening failed: %q", err)
+	}
+	socketPath := filepath.Join(t.TempDir(), "admin.sock")
+	adminListener, err := listen(unixAddressPrefix + socketPath)
+	if err != nil {
+		t.Fatalf("Listening on socket failed: %q", err)
+	}
+	doneServing := make(chan error, 1)
+	adminDoneServing := make(chan error, 1)
+	go serve(server, l, doneServing)
+	go serve(adminServer, adminListener, adminDoneServing)
+	publicUrl := "http://" + l.Addr().String()
+	adminClient := unixSocketClient(socketPath)
+
+	for _, path := range []string{"/metrics", "/admin/topics"} {
+		response := adminRequest(t, http.MethodGet, publicUrl+path, "secret")
+		if response.StatusCode != http.StatusNotFound {
+			t.Fatalf("Public %s response code was %d but expected %d", path, response.StatusCode,
+				http.StatusNotFound)
+		}
+	}
+	response, err := http.DefaultClient.Post(publicUrl+"/infocenter/admin-listener-test", "text/plain",
+		bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Public POST response code was %d but expected %d", response.StatusCode,
+			http.StatusMethodNotAllowed)
+	}
+	response, err = adminClient.Post("http://infocenter/infocenter/admin-listener-test", "text/plain",
+		bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Admin POST response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	response, err = adminClient.Get("http://infocenter/metrics")
+	if err != nil {
+		t.Fatalf("GET failed: %q", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Admin metrics response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	response, err = http.DefaultClient.Get(publicUrl + "/inbound/github")
+	if err != nil {
+		t.Fatalf("GET failed: %q", err)
+	}
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Public inbound GET response code was %d but expected %d", response.StatusCode,
+			http.StatusMethodNotAllowed)
+	}
+	response, err = http.DefaultClient.Get(publicUrl + "/healthz")
+	if err != nil {
+		t.Fatalf("GET failed: %q", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Public health response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	stopServing(t, server, doneServing)
+	stopServing(t, adminServer, adminDoneServing)
+}
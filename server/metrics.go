@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/metrics"
+	"net/http"
+)
+
+const (
+	streamEndTimeout    = "timeout"
+	streamEndDisconnect = "disconnect"
+	streamEndError      = "error"
+	streamEndClosed     = "closed"
+	streamEndShutdown   = "shutdown"
+)
+
+var (
+	eventStreamsEnded = metrics.NewCounterVec("infocenter_event_streams_ended_total",
+		"Number of ended event streams per topic and reason (timeout, disconnect, error, closed or shutdown).", "topic", "reason")
+	eventStreamBytesWritten = metrics.NewCounterVec("infocenter_event_stream_bytes_written_total",
+		"Number of bytes written to event streams per topic.", "topic")
+	eventStreamDroppedMessages = metrics.NewCounterVec("infocenter_event_stream_dropped_messages_total",
+		"Number of messages which could not be written to event streams per topic.", "topic")
+)
+
+func newMetricsRegistry(eventStreamBroker *chanbroker.Broker) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	eventStreamBroker.RegisterMetrics(registry)
+	registry.MustRegister(eventStreamsEnded, eventStreamBytesWritten, eventStreamDroppedMessages)
+	return registry
+}
+
+// deleteTopicMetrics deletes series of the topic discarded by the broker.
+func deleteTopicMetrics(topic string) {
+	eventStreamsEnded.DeleteLabelValue("topic", topic)
+	eventStreamBytesWritten.DeleteLabelValue("topic", topic)
+	eventStreamDroppedMessages.DeleteLabelValue("topic", topic)
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	topic string
+}
+
+func (w countingResponseWriter) Write(bytes []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(bytes)
+	eventStreamBytesWritten.Add(float64(n), w.topic)
+	return n, err
+}
+
+func (w countingResponseWriter) Flush() {
+	if writerFlusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		writerFlusher.Flush()
+	}
+}
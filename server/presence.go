@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/registry"
+	"io"
+	"net/http"
+)
+
+// maxClientIdLength limits client id length in bytes.
+const maxClientIdLength = 255
+
+// topicPresence is the response of presence handler.
+type topicPresence struct {
+	Topic   string   `json:"topic"`
+	Clients []string `json:"clients"`
+}
+
+// subscribeClientId returns id of the client present in the topic given by
+// clientId parameter or empty string if there is none.
+func subscribeClientId(request *http.Request, writer http.ResponseWriter) (string, bool) {
+	clientId := request.URL.Query().Get("clientId")
+	if len(clientId) > maxClientIdLength || !validEventAnyChar(clientId) {
+		writeError(writer, http.StatusBadRequest, "Invalid clientId parameter")
+		return "", false
+	}
+	return clientId, true
+}
+
+type infocenterPresenceHandler struct {
+	eventStreamBroker *chanbroker.Broker
+	topicRegistry     *registry.Registry
+}
+
+func newInfocenterPresenceHandler(eventStreamBroker *chanbroker.Broker,
+	topicRegistry *registry.Registry) *infocenterPresenceHandler {
+	return &infocenterPresenceHandler{eventStreamBroker: eventStreamBroker, topicRegistry: topicRegistry}
+}
+
+// ServeHTTP responds with ids of clients present in the topic.
+func (handler *infocenterPresenceHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	if _, ok := topicSettings(handler.topicRegistry, writer, topic); !ok {
+		return
+	}
+	writeJson(writer, http.StatusOK, topicPresence{
+		Topic:   topic,
+		Clients: handler.eventStreamBroker.Presence(topic),
+	})
+}
+
+// writePresenceEvent writes join or leave event with the client id as
+// data.
+func writePresenceEvent(idCounter *uint64, w io.Writer, m chanbroker.Presence) error {
+	event := "leave"
+	if m.Joined {
+		event = "join"
+	}
+	return writeEvent(idCounter, w, event, m.ClientID)
+}
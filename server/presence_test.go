@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func getPresence(t *testing.T, url string) topicPresence {
+	t.Helper()
+	response, err := http.DefaultClient.Get(url)
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	defer response.Body.Close()
+	var presence topicPresence
+	if err := json.NewDecoder(response.Body).Decode(&presence); err != nil {
+		t.Fatalf("Decoding presence failed: %q", err)
+	}
+	return presence
+}
+
+func TestPresence(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+	presenceUrl := baseUrl + "/infocenter/presence-test/presence"
+
+	if presence := getPresence(t, presenceUrl); presence.Topic != "presence-test" || len(presence.Clients) != 0 {
+		t.Fatalf("Unexpected presence %v", presence)
+	}
+	alice, err := http.DefaultClient.Get(baseUrl + "/infocenter/presence-test?clientId=alice")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	defer alice.Body.Close()
+	reader := bufio.NewReader(alice.Body)
+	waitSubscribers(t, baseUrl, "presence-test", 1)
+	bob, err := http.DefaultClient.Get(baseUrl + "/infocenter/presence-test?clientId=bob")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	if event := readEvent(t, reader); len(event) != 3 || event[1] != "event: join\n" || event[2] != "data: bob\n" {
+		t.Fatalf("Unexpected event %q", event)
+	}
+	if presence := getPresence(t, presenceUrl); len(presence.Clients) != 2 || presence.Clients[0] != "alice" ||
+		presence.Clients[1] != "bob" {
+		t.Fatalf("Unexpected presence %v", presence)
+	}
+	_ = bob.Body.Close()
+	if event := readEvent(t, reader); len(event) != 3 || event[1] != "event: leave\n" || event[2] != "data: bob\n" {
+		t.Fatalf("Unexpected event %q", event)
+	}
+	stopServing(t, server, doneServing)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/vaidasn/infocenter/registry"
+	"github.com/vaidasn/infocenter/webhook"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+const (
+	// topicsFileName is the file in PersistenceDirectory keeping topics
+	// declared by admin API.
+	topicsFileName = "topics.json"
+	// maxTopicSettingsSize limits topic settings size in bytes.
+	maxTopicSettingsSize = 4096
+)
+
+// TopicPolicy tells which topics may be used. DeclaredTopics are declared
+// at start. Topic lifecycle changes are dispatched to webhooks as messages
+// of LifecycleTopic unless it is empty.
+var (
+	TopicPolicy    = registry.AutoCreate
+	DeclaredTopics []string
+	LifecycleTopic = ""
+)
+
+// topicLifecycle is the message dispatched to webhooks when a topic gets
+// its first subscriber or loses its last one.
+type topicLifecycle struct {
+	Topic     string `json:"topic"`
+	Lifecycle string `json:"lifecycle"`
+}
+
+// newTopicRegistry returns registry of topics dispatching their lifecycle
+// to webhooks. Topics declared by admin API are persisted if
+// PersistenceDirectory is set.
+func newTopicRegistry(webhookDispatcher *webhook.Dispatcher) *registry.Registry {
+	fileName := ""
+	if PersistenceDirectory != "" {
+		fileName = filepath.Join(PersistenceDirectory, topicsFileName)
+	}
+	topicRegistry := registry.New(TopicPolicy, DeclaredTopics, fileName)
+	if LifecycleTopic != "" {
+		topicRegistry.OnLifecycle(func(topic string, lifecycle registry.Lifecycle) {
+			dispatchTopicLifecycle(webhookDispatcher, topic, lifecycle)
+		})
+	}
+	go func() {
+		<-upgradeHandedOver()
+		if err := topicRegistry.Load(); err != nil {
+			log.Fatal("Loading declared topics failed: ", err)
+		}
+	}()
+	return topicRegistry
+}
+
+// dispatchTopicLifecycle dispatches the lifecycle change of the topic to
+// webhooks of LifecycleTopic. Changes of LifecycleTopic itself are not.
+func dispatchTopicLifecycle(webhookDispatcher *webhook.Dispatcher, topic string, lifecycle registry.Lifecycle) {
+	if topic == LifecycleTopic {
+		return
+	}
+	message, err := json.Marshal(topicLifecycle{Topic: topic, Lifecycle: lifecycle.String()})
+	if err != nil {
+		log.Println("Encoding topic lifecycle failed: ", err)
+		return
+	}
+	webhookDispatcher.Dispatch(LifecycleTopic, string(message))
+}
+
+// topicSettings returns settings of the topic. Responds 404 if the topic
+// may not be used. Any topic may be used without registry.
+func topicSettings(topicRegistry *registry.Registry, writer http.ResponseWriter, topic string) (registry.Settings,
+	bool) {
+	if topicRegistry == nil {
+		return registry.Settings{}, true
+	}
+	settings, allowed := topicRegistry.Lookup(topic)
+	if !allowed {
+		writeError(writer, http.StatusNotFound, "Topic is not declared")
+		return registry.Settings{}, false
+	}
+	return settings, true
+}
+
+// maxMessageSize returns message size limit of the topic with the
+// settings.
+func maxMessageSize(settings registry.Settings) int64 {
+	if settings.MaxMessageSize != nil {
+		return *settings.MaxMessageSize
+	}
+	return currentSettings().maxMessageSize
+}
+
+type adminRegistry struct {
+	Policy string           `json:"policy"`
+	Topics []registry.Topic `json:"topics"`
+}
+
+type adminRegistryHandler struct {
+	topicRegistry *registry.Registry
+}
+
+// ServeHTTP responds with the topic policy and declared topics.
+func (handler adminRegistryHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writeJson(writer, http.StatusOK, adminRegistry{
+		Policy: handler.topicRegistry.Policy().String(),
+		Topics: handler.topicRegistry.Topics(),
+	})
+}
+
+type adminDeclareTopicHandler struct {
+	topicRegistry *registry.Registry
+}
+
+// ServeHTTP declares the topic with posted JSON settings.
+func (handler adminDeclareTopicHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	var settings registry.Settings
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxTopicSettingsSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&settings); err != nil {
+		writeError(writer, http.StatusBadRequest, "Invalid topic settings: "+err.Error())
+		return
+	}
+	if settings.TtlSeconds != nil && *settings.TtlSeconds < 0 ||
+		settings.MaxMessageSize != nil && *settings.MaxMessageSize < 0 {
+		writeError(writer, http.StatusBadRequest, "Invalid topic settings: must not be negative")
+		return
+	}
+	if err := handler.topicRegistry.Declare(topic, settings); err != nil {
+		log.Println("Declaring topic failed: ", err)
+		writeError(writer, http.StatusInternalServerError, "Declaring topic failed")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+type adminUndeclareTopicHandler struct {
+	topicRegistry *registry.Registry
+}
+
+func (handler adminUndeclareTopicHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	undeclared, err := handler.topicRegistry.Undeclare(mux.Vars(request)["topic"])
+	if err != nil {
+		log.Println("Undeclaring topic failed: ", err)
+		writeError(writer, http.StatusInternalServerError, "Undeclaring topic failed")
+		return
+	}
+	if !undeclared {
+		writeError(writer, http.StatusNotFound, "Topic is not declared")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/vaidasn/infocenter/registry"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func declareTopic(t *testing.T, url string, settings string) int {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(settings))
+	if err != nil {
+		t.Fatalf("Got error while creating new request: %q", err)
+	}
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("PUT failed: %q", err)
+	}
+	_ = response.Body.Close()
+	return response.StatusCode
+}
+
+func postStatusCode(t *testing.T, url string, message string) int {
+	t.Helper()
+	response, err := http.DefaultClient.Post(url, "text/plain", bytes.NewBufferString(message))
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	_ = response.Body.Close()
+	return response.StatusCode
+}
+
+func TestTopicRegistry(t *testing.T) {
+	defer setAdminToken("secret")()
+	savedTopicPolicy, savedDeclaredTopics := TopicPolicy, DeclaredTopics
+	TopicPolicy, DeclaredTopics = registry.Declared, []string{"declared-test"}
+	defer func() { TopicPolicy, DeclaredTopics = savedTopicPolicy, savedDeclaredTopics }()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+
+	if statusCode := postStatusCode(t, baseUrl+"/infocenter/declared-test", "message"); statusCode !=
+		http.StatusNoContent {
+		t.Fatalf("Response code of declared topic was %d but expected %d", statusCode, http.StatusNoContent)
+	}
+	if statusCode := postStatusCode(t, baseUrl+"/infocenter/other", "message"); statusCode != http.StatusNotFound {
+		t.Fatalf("Response code of undeclared topic was %d but expected %d", statusCode, http.StatusNotFound)
+	}
+	response, err := http.DefaultClient.Get(baseUrl + "/infocenter/other")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	if _ = response.Body.Close(); response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Response code of undeclared topic was %d but expected %d", response.StatusCode,
+			http.StatusNotFound)
+	}
+	response, err = http.DefaultClient.Get(baseUrl + "/infocenter/other/presence")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	if _ = response.Body.Close(); response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Response code of undeclared topic presence was %d but expected %d", response.StatusCode,
+			http.StatusNotFound)
+	}
+	if statusCode := postAck(t, baseUrl+"/infocenter/other/ack/1-ID"); statusCode != http.StatusNotFound {
+		t.Fatalf("Response code of undeclared topic ack was %d but expected %d", statusCode, http.StatusNotFound)
+	}
+
+	registryUrl := baseUrl + "/admin/registry/other"
+	if statusCode := declareTopic(t, registryUrl, `{"maxMessageSize":-1}`); statusCode != http.StatusBadRequest {
+		t.Fatalf("Response code of invalid settings was %d but expected %d", statusCode, http.StatusBadRequest)
+	}
+	if statusCode := declareTopic(t, registryUrl, `{"maxMessageSize":3,"retain":false}`); statusCode !=
+		http.StatusNoContent {
+		t.Fatalf("Response code of declaring was %d but expected %d", statusCode, http.StatusNoContent)
+	}
+	for message, expectedStatusCode := range map[string]int{"abc": http.StatusNoContent,
+		"abcd": http.StatusRequestEntityTooLarge} {
+		if statusCode := postStatusCode(t, baseUrl+"/infocenter/other", message); statusCode != expectedStatusCode {
+			t.Fatalf("Response code of %q was %d but expected %d", message, statusCode, expectedStatusCode)
+		}
+	}
+	if statusCode := postStatusCode(t, baseUrl+"/infocenter/other?retain=true", "abc"); statusCode !=
+		http.StatusBadRequest {
+		t.Fatalf("Response code of retained message was %d but expected %d", statusCode, http.StatusBadRequest)
+	}
+
+	response = adminRequest(t, http.MethodGet, baseUrl+"/admin/registry", "secret")
+	var declared adminRegistry
+	if err := json.NewDecoder(response.Body).Decode(&declared); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	_ = response.Body.Close()
+	if declared.Policy != "declared" || len(declared.Topics) != 2 || declared.Topics[1].Topic != "other" ||
+		*declared.Topics[1].Settings.MaxMessageSize != 3 {
+		t.Fatalf("Unexpected registry %v", declared)
+	}
+	for _, expectedStatusCode := range []int{http.StatusNoContent, http.StatusNotFound} {
+		response := adminRequest(t, http.MethodDelete, registryUrl, "secret")
+		if _ = response.Body.Close(); response.StatusCode != expectedStatusCode {
+			t.Fatalf("Response code of undeclaring was %d but expected %d", response.StatusCode,
+				expectedStatusCode)
+		}
+	}
+	stopServing(t, server, doneServing)
+}
+
+func TestTopicLifecycleWebhooks(t *testing.T) {
+	defer setAdminToken("secret")()
+	savedLifecycleTopic := LifecycleTopic
+	LifecycleTopic = "lifecycle"
+	defer func() { LifecycleTopic = savedLifecycleTopic }()
+	received := make(chan string, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		received <- string(body)
+	}))
+	defer receiver.Close()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+
+	request, _ := http.NewRequest(http.MethodPost, baseUrl+"/admin/webhooks", bytes.NewBufferString(
+		`{"pattern":"lifecycle","url":"`+receiver.URL+`","secret":"hook-secret"}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	if _ = response.Body.Close(); response.StatusCode != http.StatusCreated {
+		t.Fatalf("Response code of adding webhook was %d but expected %d", response.StatusCode,
+			http.StatusCreated)
+	}
+	subscription, err := http.DefaultClient.Get(baseUrl + "/infocenter/lifecycle-test")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	waitSubscribers(t, baseUrl, "lifecycle-test", 1)
+	_ = subscription.Body.Close()
+	for _, expected := range []string{`{"topic":"lifecycle-test","lifecycle":"firstSubscriber"}`,
+		`{"topic":"lifecycle-test","lifecycle":"lastUnsubscribed"}`} {
+		select {
+		case message := <-received:
+			if message != expected {
+				t.Fatalf("Unexpected lifecycle message %q but expected %q", message, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Webhook did not receive lifecycle message %q", expected)
+		}
+	}
+	stopServing(t, server, doneServing)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/registry"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// replyTopicPrefix starts reply topics of requests. Replies posted to
+	// them are returned to the waiting requests instead of being published.
+	replyTopicPrefix    = "_reply."
+	correlationIdHeader = "Correlation-Id"
+)
+
+// RequestTimeoutSeconds is the default and the maximum time a request waits
+// for a reply.
+var RequestTimeoutSeconds = 30
+
+// requestMessage is a request published to responders. It expires when the
+// request stops waiting for a reply.
+type requestMessage struct {
+	topicAndMessage
+	correlationId string
+	expires       time.Time
+}
+
+func (m requestMessage) Expires() time.Time {
+	return m.expires
+}
+
+// requestEvent is the data of request event.
+type requestEvent struct {
+	CorrelationId string `json:"correlationId"`
+	ReplyTopic    string `json:"replyTopic"`
+	Message       string `json:"message"`
+}
+
+func isReplyTopic(topic string) bool {
+	return strings.HasPrefix(topic, replyTopicPrefix)
+}
+
+// pendingRequests are requests waiting for replies by correlation id.
+type pendingRequests struct {
+	mutex      sync.Mutex
+	replyChs   map[string]chan string
+	shutdownCh chan struct{}
+	once       sync.Once
+}
+
+func newPendingRequests() *pendingRequests {
+	return &pendingRequests{replyChs: map[string]chan string{}, shutdownCh: make(chan struct{})}
+}
+
+func (p *pendingRequests) add(correlationId string) chan string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	replyCh := make(chan string, 1)
+	p.replyChs[correlationId] = replyCh
+	return replyCh
+}
+
+func (p *pendingRequests) remove(correlationId string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.replyChs, correlationId)
+}
+
+// reply passes the message posted to the reply topic to the waiting
+// request. Only the first reply is accepted.
+func (p *pendingRequests) reply(writer http.ResponseWriter, topic string, message string) {
+	correlationId := strings.TrimPrefix(topic, replyTopicPrefix)
+	p.mutex.Lock()
+	replyCh, ok := p.replyChs[correlationId]
+	delete(p.replyChs, correlationId)
+	p.mutex.Unlock()
+	if !ok {
+		writeError(writer, http.StatusNotFound, "No request is waiting for the reply")
+		return
+	}
+	replyCh <- message
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// shutdown makes waiting requests fail, so that they do not delay graceful
+// shutdown.
+func (p *pendingRequests) shutdown() {
+	p.once.Do(func() {
+		close(p.shutdownCh)
+	})
+}
+
+type infocenterRequestHandler struct {
+	eventStreamBroker *chanbroker.Broker
+	pendingRequests   *pendingRequests
+	topicRegistry     *registry.Registry
+}
+
+func newInfocenterRequestHandler(eventStreamBroker *chanbroker.Broker, pendingRequests *pendingRequests,
+	topicRegistry *registry.Registry) *infocenterRequestHandler {
+	return &infocenterRequestHandler{
+		eventStreamBroker: eventStreamBroker,
+		pendingRequests:   pendingRequests,
+		topicRegistry:     topicRegistry,
+	}
+}
+
+// ServeHTTP publishes the posted message as request event with a new reply
+// topic and responds with the first message posted to the reply topic.
+func (handler *infocenterRequestHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	if isReplyTopic(topic) {
+		writeError(writer, http.StatusBadRequest, "Requests to reply topics are not allowed")
+		return
+	}
+	settings, ok := topicSettings(handler.topicRegistry, writer, topic)
+	if !ok {
+		return
+	}
+	message, ok := readMessage(writer, request, maxMessageSize(settings))
+	if !ok {
+		return
+	}
+	timeoutSeconds, ok := requestTimeoutSeconds(request, writer)
+	if !ok {
+		return
+	}
+	if handler.eventStreamBroker.TopicClosed(topic) {
+		writeError(writer, http.StatusForbidden, "Topic is closed")
+		return
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	correlationId := newMessageId()
+	replyCh := handler.pendingRequests.add(correlationId)
+	defer handler.pendingRequests.remove(correlationId)
+	subscribers, ok := handler.eventStreamBroker.PublishCounted(requestMessage{
+		topicAndMessage: topicAndMessage{topic, message},
+		correlationId:   correlationId,
+		expires:         time.Now().Add(timeout),
+	})
+	if !ok {
+		writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+	if subscribers == 0 {
+		writeError(writer, http.StatusServiceUnavailable, "No responders")
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replyCh:
+		writer.Header().Set(correlationIdHeader, correlationId)
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusOK)
+		if _, err := writer.Write([]byte(reply)); err != nil {
+			log.Println("Writing response failed: ", err)
+		}
+	case <-timer.C:
+		writeError(writer, http.StatusGatewayTimeout, fmt.Sprintf("No reply within %ds", timeoutSeconds))
+	case <-handler.pendingRequests.shutdownCh:
+		writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+	case <-request.Context().Done():
+	}
+}
+
+// requestTimeoutSeconds returns the time to wait for reply given by timeout
+// parameter or RequestTimeoutSeconds which is also the maximum.
+func requestTimeoutSeconds(request *http.Request, writer http.ResponseWriter) (int, bool) {
+	maxTimeoutSeconds := currentSettings().requestTimeoutSeconds
+	value := request.URL.Query().Get("timeout")
+	if value == "" {
+		return maxTimeoutSeconds, true
+	}
+	timeoutSeconds, err := strconv.Atoi(value)
+	if err != nil || timeoutSeconds < 1 || timeoutSeconds > maxTimeoutSeconds {
+		writeError(writer, http.StatusBadRequest,
+			fmt.Sprintf("Invalid timeout parameter, expected 1 to %d seconds", maxTimeoutSeconds))
+		return 0, false
+	}
+	return timeoutSeconds, true
+}
+
+// writeRequestEvent writes request event with JSON data telling the reply
+// topic.
+func writeRequestEvent(idCounter *uint64, writer http.ResponseWriter, m requestMessage) error {
+	data, err := json.Marshal(requestEvent{
+		CorrelationId: m.correlationId,
+		ReplyTopic:    replyTopicPrefix + m.correlationId,
+		Message:       m.message,
+	})
+	if err != nil {
+		return err
+	}
+	return writeEvent(idCounter, writer, "request", string(data))
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func postRequest(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	response, err := http.DefaultClient.Post(url, "text/plain", bytes.NewBufferString("question"))
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	defer response.Body.Close()
+	bodyBuffer := bytes.Buffer{}
+	if _, err := bodyBuffer.ReadFrom(response.Body); err != nil {
+		t.Fatalf("Read body failed: %q", err)
+	}
+	return response, bodyBuffer.String()
+}
+
+// readRequestEvent returns data of the next request event of the stream.
+func readRequestEvent(t *testing.T, reader *bufio.Reader) requestEvent {
+	t.Helper()
+	requestEventRead := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading event stream failed: %q", err)
+		}
+		if line == "event: request\n" {
+			requestEventRead = true
+		} else if data := strings.TrimPrefix(line, "data: "); requestEventRead && data != line {
+			var event requestEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("Decoding request event failed: %q", err)
+			}
+			return event
+		}
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+	requestUrl := baseUrl + "/infocenter/request-test/request"
+
+	if response, _ := postRequest(t, requestUrl); response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Response code without responders was %d but expected %d", response.StatusCode,
+			http.StatusServiceUnavailable)
+	}
+
+	subscription, err := http.DefaultClient.Get(baseUrl + "/infocenter/request-test")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	defer subscription.Body.Close()
+	waitSubscribers(t, baseUrl, "request-test", 1)
+	reader := bufio.NewReader(subscription.Body)
+	replyUrlCh := make(chan string, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			event := readRequestEvent(t, reader)
+			if event.Message != "question" || event.ReplyTopic != replyTopicPrefix+event.CorrelationId {
+				t.Errorf("Unexpected request event %v", event)
+			}
+			replyUrl := baseUrl + "/infocenter/" + event.ReplyTopic
+			replyUrlCh <- replyUrl
+			if i == 0 {
+				if response, _ := postRequest(t, replyUrl); response.StatusCode != http.StatusNoContent {
+					t.Errorf("Reply response code was %d but expected %d", response.StatusCode,
+						http.StatusNoContent)
+				}
+			}
+		}
+	}()
+
+	response, body := postRequest(t, requestUrl)
+	if response.StatusCode != http.StatusOK || body != "question" || response.Header.Get(correlationIdHeader) == "" {
+		t.Fatalf("Unexpected response %d %q", response.StatusCode, body)
+	}
+	if response, _ := postRequest(t, <-replyUrlCh); response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Second reply response code was %d but expected %d", response.StatusCode, http.StatusNotFound)
+	}
+
+	if response, _ = postRequest(t, requestUrl+"?timeout=1"); response.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusGatewayTimeout)
+	}
+	if response, _ := postRequest(t, <-replyUrlCh); response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Late reply response code was %d but expected %d", response.StatusCode, http.StatusNotFound)
+	}
+	if response, _ = postRequest(t, requestUrl+"?timeout=31"); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusBadRequest)
+	}
+	stopServing(t, server, doneServing)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/scheduler"
+	"log"
+	"path/filepath"
+)
+
+// scheduledFileName is the file in PersistenceDirectory keeping messages
+// pending delayed delivery.
+const scheduledFileName = "scheduled.json"
+
+// newMessageScheduler returns scheduler publishing messages to the broker
+// when they are due. Pending messages are persisted if PersistenceDirectory
+// is set. The scheduler loads persisted messages and starts once an
+// upgrading process hands over.
+func newMessageScheduler(eventStreamBroker *chanbroker.Broker) *scheduler.Scheduler {
+	fileName := ""
+	if PersistenceDirectory != "" {
+		fileName = filepath.Join(PersistenceDirectory, scheduledFileName)
+	}
+	messageScheduler := scheduler.New(func(message scheduler.Message) {
+		deliverScheduled(eventStreamBroker, message)
+	}, fileName)
+	messageScheduler.MaxMessages = MaxScheduledMessages
+	go func() {
+		<-upgradeHandedOver()
+		if err := messageScheduler.Load(); err != nil {
+			log.Fatal("Loading scheduled messages failed: ", err)
+		}
+		messageScheduler.Start()
+	}()
+	return messageScheduler
+}
+
+// deliverScheduled publishes the due message as if it was posted now with
+// the id it was scheduled with. Time-to-live counts from delivery.
+func deliverScheduled(eventStreamBroker *chanbroker.Broker, message scheduler.Message) {
+	if message.Retain && message.Message == "" {
+		eventStreamBroker.ClearRetained(message.Topic)
+		return
+	}
+	published := newTopicMessage(message.Topic, message.Message, message.Retain, message.TtlSeconds)
+	published.id = message.ID
+	if !eventStreamBroker.Publish(published) {
+		log.Printf("Scheduled message %s was not delivered, server is shutting down", message.ID)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/vaidasn/infocenter/scheduler"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func postScheduled(t *testing.T, url string, expectedStatusCode int) scheduler.Message {
+	t.Helper()
+	response, err := http.DefaultClient.Post(url, "text/plain", bytes.NewBufferString("reminder"))
+	if err != nil {
+		t.Fatal("POST failed")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != expectedStatusCode {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, expectedStatusCode)
+	}
+	var message scheduler.Message
+	if expectedStatusCode == http.StatusAccepted {
+		if err := json.NewDecoder(response.Body).Decode(&message); err != nil {
+			t.Fatalf("Decoding response failed: %q", err)
+		}
+	}
+	return message
+}
+
+func adminScheduled(t *testing.T, url string) []scheduler.Message {
+	t.Helper()
+	response := adminRequest(t, http.MethodGet, url, "secret")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	var messages []scheduler.Message
+	if err := json.NewDecoder(response.Body).Decode(&messages); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	return messages
+}
+
+func TestScheduledDelivery(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	topicUrl := fmt.Sprintf("http://%s/infocenter/scheduled-test", l.Addr().String())
+	scheduledUrl := fmt.Sprintf("http://%s/admin/scheduled", l.Addr().String())
+
+	delayed := postScheduled(t, topicUrl+"?retain=true&delay=1", http.StatusAccepted)
+	if delayed.ID == "" || delayed.Topic != "scheduled-test" || !delayed.Retain ||
+		delayed.DeliverAt.Before(time.Now()) {
+		t.Fatalf("Unexpected scheduled message %v", delayed)
+	}
+	deliverAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	cancelled := postScheduled(t, topicUrl+"?deliver-at="+deliverAt, http.StatusAccepted)
+	postScheduled(t, topicUrl+"?deliver-at=tomorrow", http.StatusBadRequest)
+	postScheduled(t, topicUrl+"?delay=-1", http.StatusBadRequest)
+	postScheduled(t, topicUrl+"?delay=1&deliver-at="+deliverAt, http.StatusBadRequest)
+
+	if messages := adminScheduled(t, scheduledUrl+"?topic=scheduled-test"); len(messages) != 2 ||
+		messages[0].ID != delayed.ID || messages[1].ID != cancelled.ID {
+		t.Fatalf("Unexpected scheduled messages %v", messages)
+	}
+	if messages := adminScheduled(t, scheduledUrl+"?topic=other"); len(messages) != 0 {
+		t.Fatalf("Unexpected scheduled messages %v", messages)
+	}
+	response := adminRequest(t, http.MethodDelete, scheduledUrl+"/"+cancelled.ID, "secret")
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	response = adminRequest(t, http.MethodDelete, scheduledUrl+"/"+cancelled.ID, "secret")
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNotFound)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); len(adminScheduled(t, scheduledUrl)) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Scheduled message was not delivered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	response = adminRequest(t, http.MethodGet,
+		fmt.Sprintf("http://%s/admin/topics/scheduled-test", l.Addr().String()), "secret")
+	var topic adminTopic
+	if err := json.NewDecoder(response.Body).Decode(&topic); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	if topic.Messages != 1 || !topic.Retained {
+		t.Fatalf("Unexpected topic %v", topic)
+	}
+	stopServing(t, server, doneServing)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/vaidasn/infocenter/selector"
+	"net/http"
+)
+
+// subscribeSelector returns selector of messages written to the event
+// stream given by selector parameter or nil if there is none. Consumer
+// groups do not support selectors, since a message skipped by the member
+// it was delivered to would be lost for the group.
+func subscribeSelector(request *http.Request, writer http.ResponseWriter) (*selector.Selector, bool) {
+	expression := request.URL.Query().Get("selector")
+	if expression == "" {
+		return nil, true
+	}
+	if request.URL.Query().Get("group") != "" {
+		writeError(writer, http.StatusBadRequest, "Selector is not supported with group parameter")
+		return nil, false
+	}
+	messageSelector, err := selector.Parse(expression)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Invalid selector parameter: "+err.Error())
+		return nil, false
+	}
+	return messageSelector, true
+}
+
+// messageSelected returns true if the message matches the selector. Fields
+// are topic, message, retained and data, the message decoded from JSON or
+// null if it is not JSON. Other than topic messages are always selected.
+func messageSelected(messageSelector *selector.Selector, m interface{}, retained bool) bool {
+	var message topicAndMessage
+	switch m := m.(type) {
+	case topicAndMessage:
+		message = m
+	case requestMessage:
+		message = m.topicAndMessage
+	default:
+		return true
+	}
+	var data interface{}
+	decoded := false
+	return messageSelector.Match(func(name string) (interface{}, bool) {
+		switch name {
+		case "topic":
+			return message.topic, true
+		case "message":
+			return message.message, true
+		case "retained":
+			return retained, true
+		case "data":
+			if !decoded {
+				if err := json.Unmarshal([]byte(message.message), &data); err != nil {
+					data = nil
+				}
+				decoded = true
+			}
+			return data, true
+		}
+		return nil, false
+	})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestGetSelector(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+
+	for _, query := range []string{"selector=" + url.QueryEscape(`data.region ==`),
+		"group=workers&selector=" + url.QueryEscape(`data.region == "eu"`)} {
+		response, err := http.DefaultClient.Get(baseUrl + "/infocenter/selector-test?" + query)
+		if err != nil {
+			t.Fatal("GET failed")
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Response code of %s was %d but expected %d", query, response.StatusCode,
+				http.StatusBadRequest)
+		}
+	}
+
+	response, err := http.DefaultClient.Get(baseUrl + "/infocenter/selector-test?selector=" +
+		url.QueryEscape(`data.region == "eu" && !retained`))
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	waitSubscribers(t, baseUrl, "selector-test", 1)
+	for _, message := range []string{"not json", `{"region":"us"}`, `{"region":"eu","id":1}`, `{"region":"eu","id":2}`} {
+		postUrl := baseUrl + "/infocenter/selector-test"
+		if message == `{"region":"eu","id":1}` {
+			postUrl += "?retain=true"
+		}
+		response, err := http.DefaultClient.Post(postUrl, "application/json", bytes.NewBufferString(message))
+		if err != nil {
+			t.Fatal("POST failed")
+		}
+		_ = response.Body.Close()
+	}
+	if data := readMessageData(t, reader); data != `{"region":"eu","id":2}` {
+		t.Fatalf("Selected message was %q", data)
+	}
+	stopServing(t, server, doneServing)
+}
@@ -2,78 +2,640 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/idempotency"
+	"github.com/vaidasn/infocenter/inbound"
+	"github.com/vaidasn/infocenter/metrics"
+	"github.com/vaidasn/infocenter/registry"
+	"github.com/vaidasn/infocenter/scheduler"
+	"github.com/vaidasn/infocenter/selector"
+	"github.com/vaidasn/infocenter/webhook"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// ListenAndServe serves on inherited listeners, on ListenAddresses or on
+// all interfaces at port if there are none. Admin API and metrics are
+// served on AdminListenAddresses only if they are set. On SIGUSR2 the
+// listeners are passed to a new process of the same executable and this
+// one shuts down once the new one serves.
 func ListenAndServe(port uint16) {
-	server := NewServer()
-	server.Addr = fmt.Sprintf(":%d", port)
-	log.Fatal(server.ListenAndServe())
+	listeners, err := openListeners(port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server, adminServer, services := newServers(len(listeners.admin) != 0)
+	if tlsEnabled() {
+		tlsConfig, err := newTLSConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = tlsConfig
+		if adminServer != nil {
+			adminServer.TLSConfig = tlsConfig
+		}
+	}
+	doneServing := make(chan error, len(listeners.public)+len(listeners.admin))
+	serveAll(server, listeners.public, doneServing)
+	servers := []*http.Server{server}
+	if adminServer != nil {
+		serveAll(adminServer, listeners.admin, doneServing)
+		servers = append(servers, adminServer)
+	}
+	notifyUpgradeReady()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	var handover *os.File
+	for shuttingDown := false; !shuttingDown; {
+		select {
+		case err := <-doneServing:
+			log.Fatal(err)
+		case s := <-signals:
+			switch s {
+			case syscall.SIGHUP:
+				reloadOnSignal()
+			case syscall.SIGUSR2:
+				handover = upgradeOnSignal(listeners)
+				shuttingDown = handover != nil
+			default:
+				log.Printf("Received %s signal, shutting down", s)
+				shuttingDown = true
+			}
+		}
+	}
+	if handover != nil {
+		// Upgraded process accepts connections on the same listeners, so
+		// readiness must not fail.
+		keepUnixSockets(listeners)
+	} else {
+		services.readyzHandler.startDraining()
+		time.Sleep(time.Duration(currentSettings().shutdownDelaySeconds) * time.Second)
+	}
+	shutdownGracefully(servers...)
+	services.stop()
+	if handover != nil {
+		// Upgraded process loads persisted state once it is written.
+		_ = handover.Close()
+	}
+}
+
+// shutdownGracefully waits up to ShutdownTimeoutSeconds until event streams
+// receive shutdown event and close. Remaining connections are closed
+// forcibly after the timeout.
+func shutdownGracefully(servers ...*http.Server) {
+	shutdownTimeout := time.Duration(currentSettings().shutdownTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Println("Graceful shutdown failed: ", err)
+				if err := server.Close(); err != nil {
+					log.Println("Closing server failed: ", err)
+				}
+			}
+		}(server)
+	}
+	wg.Wait()
 }
 
+// NewServer returns server which notifies event stream subscribers on
+// Shutdown. Subscribers receive shutdown event after all messages
+// published before Shutdown. Its broker keeps running until the process
+// exits.
 func NewServer() *http.Server {
-	eventStreamBroker := newEventStreamBroker()
-	r := configRoutes(eventStreamBroker)
-	server := &http.Server{Handler: r}
-	server.RegisterOnShutdown(func() {
-		eventStreamBroker.Stop()
-	})
+	server, _, _ := newServers(false)
 	return server
 }
 
+// services are shared by the server of all routes and the server of
+// subscriber routes.
+type services struct {
+	eventStreamBroker *chanbroker.Broker
+	messageScheduler  *scheduler.Scheduler
+	idempotencyStore  *idempotency.Store
+	pendingRequests   *pendingRequests
+	topicRegistry     *registry.Registry
+	webhookDispatcher *webhook.Dispatcher
+	inboundAdapter    *inbound.Adapter
+	metricsRegistry   *metrics.Registry
+	readyzHandler     *readyzHandler
+	shutdownOnce      sync.Once
+	stopOnce          sync.Once
+	// requestsMutex serializes counting requests with stop, so that stop
+	// waits for all requests it did not reject.
+	requestsMutex sync.Mutex
+	requests      sync.WaitGroup
+	stopping      bool
+}
+
+func newServices() *services {
+	webhookDispatcher := newWebhookDispatcher()
+	topicRegistry := newTopicRegistry(webhookDispatcher)
+	options := BrokerOptions
+	options.FirstSubscriber = func(topic string) { topicRegistry.Fire(topic, registry.FirstSubscriber) }
+	options.LastUnsubscribed = func(topic string) { topicRegistry.Fire(topic, registry.LastUnsubscribed) }
+	options.Published = func(msg interface{}) { dispatchToWebhooks(webhookDispatcher, msg) }
+	options.TopicDiscarded = deleteTopicMetrics
+	eventStreamBroker := startEventStreamBroker(options)
+	return &services{
+		eventStreamBroker: eventStreamBroker,
+		messageScheduler:  newMessageScheduler(eventStreamBroker),
+		idempotencyStore:  newIdempotencyStore(),
+		pendingRequests:   newPendingRequests(),
+		topicRegistry:     topicRegistry,
+		webhookDispatcher: webhookDispatcher,
+		inboundAdapter:    newInboundAdapter(),
+		metricsRegistry:   newMetricsRegistry(eventStreamBroker),
+		readyzHandler:     newReadyzHandler(eventStreamBroker),
+	}
+}
+
+// shutdown stops the scheduler first, so that no message is delivered to
+// the broker shutting down. Servers sharing the services shut them down
+// once.
+func (s *services) shutdown() {
+	s.shutdownOnce.Do(func() {
+		s.pendingRequests.shutdown()
+		s.messageScheduler.Stop()
+		s.eventStreamBroker.Shutdown()
+		s.webhookDispatcher.Stop()
+		s.idempotencyStore.Stop()
+	})
+}
+
+// stop shuts the services down unless they are already, waits until all
+// request handlers return and then stops the broker. It is called once
+// the servers shut down, as handlers of event streams a client has reset
+// may still be returning then. Later requests are rejected.
+func (s *services) stop() {
+	s.shutdown()
+	s.stopOnce.Do(func() {
+		s.requestsMutex.Lock()
+		s.stopping = true
+		s.requestsMutex.Unlock()
+		s.requests.Wait()
+		s.eventStreamBroker.Stop()
+	})
+}
+
+// beginRequest counts a request unless the services are stopping. Counted
+// requests must call requests.Done when their handler returns.
+func (s *services) beginRequest() bool {
+	s.requestsMutex.Lock()
+	defer s.requestsMutex.Unlock()
+	if s.stopping {
+		return false
+	}
+	s.requests.Add(1)
+	return true
+}
+
+// countingHandler serves requests counted by the services.
+type countingHandler struct {
+	http.Handler
+	services *services
+}
+
+func (handler countingHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !handler.services.beginRequest() {
+		writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+	defer handler.services.requests.Done()
+	handler.Handler.ServeHTTP(writer, request)
+}
+
+// newServers returns server of all routes, or server of subscriber routes
+// and admin server of all routes sharing the same services if
+// separateAdmin is true. The services must be stopped once the servers
+// shut down.
+func newServers(separateAdmin bool) (server *http.Server, adminServer *http.Server, services *services) {
+	services = newServices()
+	newServer := func(routes routeSet) *http.Server {
+		server := &http.Server{Handler: countingHandler{configRoutes(services, routes), services},
+			Protocols: serverProtocols()}
+		server.RegisterOnShutdown(services.shutdown)
+		return server
+	}
+	if !separateAdmin {
+		return newServer(allRoutes), nil, services
+	}
+	return newServer(subscriberRoutes), newServer(allRoutes), services
+}
+
+// serverProtocols enables HTTP/2 so that clients may multiplex many event
+// streams over a single connection instead of being limited by per origin
+// HTTP/1.1 connection limits of browsers.
+func serverProtocols() *http.Protocols {
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(H2C)
+	return protocols
+}
+
 func newEventStreamBroker() *chanbroker.Broker {
-	eventStreamBroker := chanbroker.NewBroker()
+	return startEventStreamBroker(BrokerOptions)
+}
+
+func startEventStreamBroker(options chanbroker.Options) *chanbroker.Broker {
+	eventStreamBroker := chanbroker.NewBrokerWithOptions(options)
 	go eventStreamBroker.Start()
 	return eventStreamBroker
 }
 
-func configRoutes(eventStreamBroker *chanbroker.Broker) *mux.Router {
+// routeSet selects routes a server serves.
+type routeSet int
+
+const (
+	allRoutes routeSet = iota
+	// subscriberRoutes are event streams with their acknowledgements and
+	// presence, inbound webhooks, and health checks only.
+	subscriberRoutes
+)
+
+func configRoutes(services *services, routes routeSet) *mux.Router {
 	r := mux.NewRouter()
-	r.Handle("/infocenter/{topic}", newInfocenterPostHandler(eventStreamBroker)).Methods(http.MethodPost)
-	r.Handle("/infocenter/{topic}", newInfocenterGetHandler(eventStreamBroker)).Methods(http.MethodGet)
+	r.Use(corsMiddleware)
+	preflight := func(path string) {
+		r.Handle(path, corsPreflightHandler{}).Methods(http.MethodOptions)
+	}
+	r.Handle("/healthz", healthzHandler{}).Methods(http.MethodGet)
+	r.Handle("/readyz", services.readyzHandler).Methods(http.MethodGet)
+	r.Handle(RoutesPrefix+"/{topic}",
+		newInfocenterGetHandler(services.eventStreamBroker, services.topicRegistry)).Methods(http.MethodGet)
+	r.Handle(RoutesPrefix+"/{topic}/ack/{id}",
+		newInfocenterAckHandler(services.eventStreamBroker, services.topicRegistry)).Methods(http.MethodPost)
+	r.Handle(RoutesPrefix+"/{topic}/presence",
+		newInfocenterPresenceHandler(services.eventStreamBroker, services.topicRegistry)).Methods(http.MethodGet)
+	for _, path := range []string{RoutesPrefix + "/{topic}", RoutesPrefix + "/{topic}/ack/{id}",
+		RoutesPrefix + "/{topic}/presence"} {
+		preflight(path)
+	}
+	r.Handle("/inbound/{source}", newInboundWebhookHandler(services.eventStreamBroker, services.inboundAdapter,
+		services.topicRegistry)).Methods(http.MethodPost)
+	if routes == subscriberRoutes {
+		return r
+	}
+	r.Handle("/metrics", services.metricsRegistry).Methods(http.MethodGet)
+	r.Handle(RoutesPrefix+"/{topic}", newInfocenterPostHandler(services.eventStreamBroker,
+		services.messageScheduler, services.idempotencyStore, services.pendingRequests,
+		services.topicRegistry)).Methods(http.MethodPost)
+	r.Handle(RoutesPrefix+"/{topic}/request", newInfocenterRequestHandler(services.eventStreamBroker,
+		services.pendingRequests, services.topicRegistry)).Methods(http.MethodPost)
+	preflight(RoutesPrefix + "/{topic}/request")
+	configAdminRoutes(r, services)
 	return r
 }
 
+// RoutesPrefix is the URL path prefix of topic routes.
+var RoutesPrefix = "/infocenter"
+
+var BrokerOptions = chanbroker.DefaultOptions
+
 var EventStreamTimeoutSeconds = 30
 
+// MaxMessageSize limits posted message size in bytes if it is positive.
+var MaxMessageSize int64 = 0
+
+// DefaultTtlSeconds is the time-to-live of messages posted without ttl
+// parameter or 0 if they do not expire. TopicTtlSeconds overrides it for
+// the topics.
+var (
+	DefaultTtlSeconds = 0
+	TopicTtlSeconds   = map[string]int{}
+)
+
+// PersistenceDirectory must be writable for the server to be ready if it
+// is not empty.
+var PersistenceDirectory = ""
+
+// MaxScheduledMessages limits messages pending delayed delivery if it is
+// positive.
+var MaxScheduledMessages = 10000
+
+// H2C enables HTTP/2 without TLS (h2c) for clients using prior knowledge.
+var H2C = false
+
+// ShutdownTimeoutSeconds limits graceful shutdown duration.
+var ShutdownTimeoutSeconds = 30
+
+// ShutdownDelaySeconds is the time between failing readiness and shutting
+// down, so that traffic gets routed elsewhere first.
+var ShutdownDelaySeconds = 0
+
+// ShutdownRetrySeconds is the reconnection delay suggested to event stream
+// subscribers in shutdown event.
+var ShutdownRetrySeconds = 5
+
 type topicAndMessage struct {
 	topic   string
 	message string
 }
 
+func (m topicAndMessage) Topic() string {
+	return m.topic
+}
+
+// publishedMessage is a topic message published with options. The id is
+// returned to the publisher and written as event id to subscribers.
+// Retained message is kept by the broker and delivered to new topic
+// subscribers. Message expiring at non-zero time is not delivered after
+// it. Message with event type is written as event of the type instead of
+// msg event.
+type publishedMessage struct {
+	topicAndMessage
+	id       string
+	retained bool
+	expires  time.Time
+	event    string
+}
+
+func (m publishedMessage) Retained() bool {
+	return m.retained
+}
+
+func (m publishedMessage) Expires() time.Time {
+	return m.expires
+}
+
 type infocenterPostHandler struct {
 	eventStreamBroker *chanbroker.Broker
+	messageScheduler  *scheduler.Scheduler
+	idempotencyStore  *idempotency.Store
+	pendingRequests   *pendingRequests
+	topicRegistry     *registry.Registry
 }
 
-func newInfocenterPostHandler(eventStreamBroker *chanbroker.Broker) *infocenterPostHandler {
-	return &infocenterPostHandler{eventStreamBroker: eventStreamBroker}
+func newInfocenterPostHandler(eventStreamBroker *chanbroker.Broker, messageScheduler *scheduler.Scheduler,
+	idempotencyStore *idempotency.Store, pendingRequests *pendingRequests,
+	topicRegistry *registry.Registry) *infocenterPostHandler {
+	return &infocenterPostHandler{
+		eventStreamBroker: eventStreamBroker,
+		messageScheduler:  messageScheduler,
+		idempotencyStore:  idempotencyStore,
+		pendingRequests:   pendingRequests,
+		topicRegistry:     topicRegistry,
+	}
 }
 
 func (handler *infocenterPostHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := requestTopic(request, writer)
+	if !ok {
+		return
+	}
+	if handler.pendingRequests != nil && isReplyTopic(topic) {
+		if message, ok := readMessage(writer, request, currentSettings().maxMessageSize); ok {
+			handler.pendingRequests.reply(writer, topic, message)
+		}
+		return
+	}
+	settings, ok := topicSettings(handler.topicRegistry, writer, topic)
+	if !ok {
+		return
+	}
+	message, ok := readMessage(writer, request, maxMessageSize(settings))
+	if !ok {
+		return
+	}
+	retain := false
+	if value := request.URL.Query().Get("retain"); value != "" {
+		var err error
+		if retain, err = strconv.ParseBool(value); err != nil {
+			writeError(writer, http.StatusBadRequest, "Invalid retain parameter")
+			return
+		}
+	}
+	if retain && settings.Retain != nil && !*settings.Retain {
+		writeError(writer, http.StatusBadRequest, "Topic does not allow retained messages")
+		return
+	}
+	ttlSeconds, ok := messageTtlSeconds(request, writer, topic, settings)
+	if !ok {
+		return
+	}
+	deliverAt, ok := messageDeliverAt(request, writer)
+	if !ok {
+		return
+	}
+	if key := request.Header.Get(idempotencyKeyHeader); key != "" && handler.idempotencyStore != nil {
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(writer, http.StatusBadRequest, "Idempotency key is too long")
+			return
+		}
+		response, result := handler.idempotencyStore.Begin(topic, key)
+		switch result {
+		case idempotency.Replayed:
+			writeReplayed(writer, response)
+			return
+		case idempotency.InProgress:
+			writeError(writer, http.StatusConflict, "Request with the same idempotency key is in progress")
+			return
+		}
+		recorder := &recordingResponseWriter{ResponseWriter: writer}
+		defer func() {
+			// Only successful responses are remembered, failed requests
+			// may be retried.
+			if recorder.statusCode >= 200 && recorder.statusCode < 300 {
+				handler.idempotencyStore.Complete(topic, key, recorder.response())
+			} else {
+				handler.idempotencyStore.Abort(topic, key)
+			}
+		}()
+		writer = recorder
+	}
+	if handler.eventStreamBroker.TopicClosed(topic) {
+		writeError(writer, http.StatusForbidden, "Topic is closed")
+		return
+	}
+	if !deliverAt.IsZero() {
+		handler.schedule(writer, scheduler.Message{
+			Topic: topic, Message: message, DeliverAt: deliverAt, Retain: retain, TtlSeconds: ttlSeconds,
+		})
+		return
+	}
+	if retain && message == "" {
+		// Empty retained message clears the retained message as in MQTT.
+		handler.eventStreamBroker.ClearRetained(topic)
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	topicMessage := newTopicMessage(topic, message, retain, ttlSeconds)
+	if preferRepresentation(request) {
+		handler.publishCounted(writer, topicMessage)
+		return
+	}
+	if !handler.eventStreamBroker.Publish(topicMessage) {
+		writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+	writer.Header().Set(messageIdHeader, topicMessage.id)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// publishResult describes the published message in response to a post
+// preferring representation.
+type publishResult struct {
+	ID          string    `json:"id"`
+	Topic       string    `json:"topic"`
+	Timestamp   time.Time `json:"timestamp"`
+	Subscribers int       `json:"subscribers"`
+}
+
+// publishCounted publishes the message waiting until it is delivered, so
+// that the response tells how many subscribers received it.
+func (handler *infocenterPostHandler) publishCounted(writer http.ResponseWriter, message publishedMessage) {
+	timestamp := time.Now().UTC()
+	subscribers, ok := handler.eventStreamBroker.PublishCounted(message)
+	if !ok {
+		writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+	result := publishResult{ID: message.id, Topic: message.topic, Timestamp: timestamp, Subscribers: subscribers}
+	writer.Header().Set(messageIdHeader, result.ID)
+	writer.Header().Set("Preference-Applied", "return=representation")
+	writeJson(writer, http.StatusCreated, result)
+}
+
+// preferRepresentation returns true if the request has header
+// Prefer: return=representation as in RFC 7240.
+func preferRepresentation(request *http.Request) bool {
+	for _, header := range request.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			preference = strings.TrimSpace(strings.SplitN(preference, ";", 2)[0])
+			if strings.EqualFold(strings.ReplaceAll(preference, " ", ""), "return=representation") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage reads posted message limited by maxMessageSize if it is
+// positive.
+func readMessage(writer http.ResponseWriter, request *http.Request, maxMessageSize int64) (string, bool) {
 	bodyBuffer := bytes.Buffer{}
+	if maxMessageSize > 0 {
+		request.Body = http.MaxBytesReader(writer, request.Body, maxMessageSize)
+	}
 	if _, err := bodyBuffer.ReadFrom(request.Body); err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			writeError(writer, http.StatusRequestEntityTooLarge, "Message is too large")
+			return "", false
+		}
 		writer.WriteHeader(http.StatusInternalServerError)
 		if _, err = writer.Write([]byte(err.Error())); err != nil {
 			log.Println("Writing response failed: ", err)
 		}
+		return "", false
+	}
+	return legalMessage(bodyBuffer.String()), true
+}
+
+// schedule accepts the message for delivery at a later time.
+func (handler *infocenterPostHandler) schedule(writer http.ResponseWriter, message scheduler.Message) {
+	if handler.messageScheduler == nil {
+		writeError(writer, http.StatusNotImplemented, "Scheduled delivery is not available")
 		return
 	}
-	message := legalMessage(bodyBuffer.String())
-	topic, ok := requestTopic(request, writer)
-	if !ok {
+	message, err := handler.messageScheduler.Schedule(message)
+	if errors.Is(err, scheduler.ErrTooManyMessages) {
+		writeError(writer, http.StatusTooManyRequests, "Too many scheduled messages")
+		return
+	} else if err != nil {
+		log.Println("Scheduling message failed: ", err)
+		writeError(writer, http.StatusInternalServerError, "Scheduling message failed")
 		return
 	}
-	handler.eventStreamBroker.Publish(topicAndMessage{topic, message})
-	writer.WriteHeader(http.StatusNoContent)
+	writer.Header().Set(messageIdHeader, message.ID)
+	writeJson(writer, http.StatusAccepted, message)
+}
+
+// newTopicMessage returns message to publish with options and a new id.
+func newTopicMessage(topic string, message string, retain bool, ttlSeconds int) publishedMessage {
+	published := publishedMessage{topicAndMessage: topicAndMessage{topic, message}, id: newMessageId(),
+		retained: retain}
+	if ttlSeconds > 0 {
+		published.expires = time.Now().Add(time.Duration(ttlSeconds) * time.Second)
+	}
+	return published
+}
+
+// messageDeliverAt returns delivery time of posted message given by
+// deliver-at parameter as RFC 3339 time or by delay parameter in seconds.
+// Zero time means the message is delivered at once.
+func messageDeliverAt(request *http.Request, writer http.ResponseWriter) (time.Time, bool) {
+	query := request.URL.Query()
+	deliverAtValue, delayValue := query.Get("deliver-at"), query.Get("delay")
+	switch {
+	case deliverAtValue != "" && delayValue != "":
+		writeError(writer, http.StatusBadRequest, "Only one of deliver-at and delay parameters is allowed")
+		return time.Time{}, false
+	case deliverAtValue != "":
+		deliverAt, err := time.Parse(time.RFC3339, deliverAtValue)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, "Invalid deliver-at parameter")
+			return time.Time{}, false
+		}
+		if !deliverAt.After(time.Now()) {
+			return time.Time{}, true
+		}
+		return deliverAt.UTC(), true
+	case delayValue != "":
+		delaySeconds, err := strconv.Atoi(delayValue)
+		if err != nil || delaySeconds < 0 {
+			writeError(writer, http.StatusBadRequest, "Invalid delay parameter")
+			return time.Time{}, false
+		}
+		if delaySeconds == 0 {
+			return time.Time{}, true
+		}
+		return time.Now().UTC().Add(time.Duration(delaySeconds) * time.Second), true
+	}
+	return time.Time{}, true
+}
+
+// messageTtlSeconds returns time-to-live of posted message given by ttl
+// parameter, topic settings or configured for the topic. Zero means the
+// message does not expire.
+func messageTtlSeconds(request *http.Request, writer http.ResponseWriter, topic string,
+	topicSettings registry.Settings) (int, bool) {
+	if value := request.URL.Query().Get("ttl"); value != "" {
+		ttlSeconds, err := strconv.Atoi(value)
+		if err != nil || ttlSeconds < 0 {
+			writeError(writer, http.StatusBadRequest, "Invalid ttl parameter")
+			return 0, false
+		}
+		return ttlSeconds, true
+	}
+	if topicSettings.TtlSeconds != nil {
+		return *topicSettings.TtlSeconds, true
+	}
+	settings := currentSettings()
+	if ttlSeconds, ok := settings.topicTtlSeconds[topic]; ok {
+		return ttlSeconds, true
+	}
+	return settings.defaultTtlSeconds, true
 }
 
 func legalMessage(body string) (message string) {
@@ -84,12 +646,14 @@ func legalMessage(body string) (message string) {
 
 type infocenterGetHandler struct {
 	eventStreamBroker          *chanbroker.Broker
+	topicRegistry              *registry.Registry
 	idCounter                  uint64
 	aboutToEnterSelectLoopFunc func()
 }
 
-func newInfocenterGetHandler(eventStreamBroker *chanbroker.Broker) *infocenterGetHandler {
-	return &infocenterGetHandler{eventStreamBroker: eventStreamBroker}
+func newInfocenterGetHandler(eventStreamBroker *chanbroker.Broker,
+	topicRegistry *registry.Registry) *infocenterGetHandler {
+	return &infocenterGetHandler{eventStreamBroker: eventStreamBroker, topicRegistry: topicRegistry}
 }
 
 func (handler *infocenterGetHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
@@ -97,43 +661,128 @@ func (handler *infocenterGetHandler) ServeHTTP(writer http.ResponseWriter, reque
 	if !ok {
 		return
 	}
+	if _, ok := topicSettings(handler.topicRegistry, writer, topic); !ok {
+		return
+	}
+	if _, ok := subscribeAck(request, writer); !ok {
+		return
+	}
+	messageSelector, ok := subscribeSelector(request, writer)
+	if !ok {
+		return
+	}
+	if _, ok := subscribeClientId(request, writer); !ok {
+		return
+	}
+	if handler.eventStreamBroker.ShuttingDown() {
+		writer.Header().Set("Retry-After", strconv.Itoa(currentSettings().shutdownRetrySeconds))
+		writeError(writer, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
 	writer.Header().Set("Cache-Control", "no-cache")
 	writer.Header().Set("Content-Type", "text/event-stream")
 	writer.WriteHeader(http.StatusOK)
 	if writerFlusher, ok := writer.(http.Flusher); ok {
 		writerFlusher.Flush()
 	}
-	messageLoop(handler, writer, request, topic)
+	messageLoop(handler, writer, request, topic, messageSelector)
 }
 
-func messageLoop(handler *infocenterGetHandler, writer http.ResponseWriter, request *http.Request, topic string) {
-	messageChannel := handler.eventStreamBroker.Subscribe()
+// messageLoop writes messages of the topic to the event stream. Messages
+// not matching the selector are skipped unless it is nil.
+func messageLoop(handler *infocenterGetHandler, writer http.ResponseWriter, request *http.Request, topic string,
+	messageSelector *selector.Selector) {
+	query := request.URL.Query()
+	ack, _ := strconv.ParseBool(query.Get("ack"))
+	messageChannel := handler.eventStreamBroker.SubscribeWith(topic, chanbroker.SubscribeOptions{
+		Group:    query.Get("group"),
+		Ack:      ack,
+		ClientID: query.Get("clientId"),
+	})
 	defer handler.eventStreamBroker.Unsubscribe(messageChannel)
+	writer = countingResponseWriter{ResponseWriter: writer, topic: topic}
 	if handler.aboutToEnterSelectLoopFunc != nil {
 		handler.aboutToEnterSelectLoopFunc()
 	}
-	requestTimeoutTimer := time.NewTimer(time.Duration(EventStreamTimeoutSeconds) * time.Second)
+	eventStreamTimeoutSeconds := currentSettings().eventStreamTimeoutSeconds
+	requestTimeoutTimer := time.NewTimer(time.Duration(eventStreamTimeoutSeconds) * time.Second)
 	defer requestTimeoutTimer.Stop()
 	context := request.Context()
 	for {
 		select {
 		case m := <-messageChannel:
-			topicAndMessage := m.(topicAndMessage)
-			if topicAndMessage.topic != topic {
-				break
+			var delivery chanbroker.Delivery
+			if d, ok := m.(chanbroker.Delivery); ok {
+				delivery, m = d, d.Message
 			}
-			if err := writeEvent(&handler.idCounter, writer, "msg", topicAndMessage.message); err != nil {
-				log.Println("Writing response failed: ", err)
+			if deadLetter, ok := m.(chanbroker.DeadLetter); ok {
+				m = deadLetter.Message
+			}
+			if chanbroker.MessageExpired(m, time.Now()) {
+				continue
+			}
+			var expires time.Time
+			id, retained, event := "", false, "msg"
+			if published, ok := m.(publishedMessage); ok {
+				m, id, expires, retained = published.topicAndMessage, published.id, published.expires,
+					published.retained
+				if published.event != "" {
+					event = published.event
+				}
+			}
+			if messageSelector != nil && !messageSelected(messageSelector, m, retained) {
+				continue
+			}
+			switch m := m.(type) {
+			case requestMessage:
+				if err := writeRequestEvent(&handler.idCounter, writer, m); err != nil {
+					log.Println("Writing response failed: ", err)
+					eventStreamDroppedMessages.Inc(topic)
+					eventStreamsEnded.Inc(topic, streamEndError)
+					return
+				}
+			case topicAndMessage:
+				var err error
+				if delivery.ID != "" {
+					err = writeDeliveryEvent(writer, delivery, event, m.message, expires)
+				} else {
+					err = writeMessageEvent(&handler.idCounter, writer, id, event, m.message, expires)
+				}
+				if err != nil {
+					log.Println("Writing response failed: ", err)
+					eventStreamDroppedMessages.Inc(topic)
+					eventStreamsEnded.Inc(topic, streamEndError)
+					return
+				}
+			case chanbroker.Presence:
+				if err := writePresenceEvent(&handler.idCounter, writer, m); err != nil {
+					log.Println("Writing response failed: ", err)
+					eventStreamsEnded.Inc(topic, streamEndError)
+					return
+				}
+			case chanbroker.Closed:
+				eventStreamsEnded.Inc(topic, streamEndClosed)
+				if err := writeEvent(&handler.idCounter, writer, "closed", m.Reason); err != nil {
+					log.Println("Writing response failed: ", err)
+				}
+				return
+			case chanbroker.Shutdown:
+				eventStreamsEnded.Inc(topic, streamEndShutdown)
+				if err := writeShutdownEvent(&handler.idCounter, writer); err != nil {
+					log.Println("Writing response failed: ", err)
+				}
 				return
 			}
 		case <-requestTimeoutTimer.C:
 			handler.eventStreamBroker.Unsubscribe(messageChannel)
-			timeoutMessage := fmt.Sprintf("%ds", EventStreamTimeoutSeconds)
+			eventStreamsEnded.Inc(topic, streamEndTimeout)
+			timeoutMessage := fmt.Sprintf("%ds", eventStreamTimeoutSeconds)
 			if err := writeEvent(&handler.idCounter, writer, "timeout", timeoutMessage); err != nil {
 				log.Println("Writing response failed: ", err)
 			}
 			return
 		case <-context.Done():
+			eventStreamsEnded.Inc(topic, streamEndDisconnect)
 			return
 		}
 	}
@@ -152,9 +801,35 @@ func requestTopic(request *http.Request, writer http.ResponseWriter) (topic stri
 }
 
 func writeEvent(idCounter *uint64, w io.Writer, event string, data string) error {
+	if err := validateEvent(event, data); err != nil {
+		return err
+	}
+	return writeEventWithId(w, strconv.FormatUint(atomic.AddUint64(idCounter, 1), 10), event, data)
+}
+
+// writeEventWithId writes event validated by validateEvent.
+func writeEventWithId(w io.Writer, id string, event string, data string) error {
 	if writerFlusher, ok := w.(http.Flusher); ok {
 		defer writerFlusher.Flush()
 	}
+	if _, err := w.Write([]byte(fmt.Sprintln("id:", id))); err != nil {
+		return err
+	}
+	if event != "" {
+		if _, err := w.Write([]byte(fmt.Sprintln("event:", event))); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write([]byte(fmt.Sprintln("data:", data))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("\n")); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateEvent(event string, data string) error {
 	if !validEventAnyChar(data) {
 		return errors.New("invalid event data")
 	}
@@ -163,21 +838,42 @@ func writeEvent(idCounter *uint64, w io.Writer, event string, data string) error
 			return errors.New("invalid event name")
 		}
 	}
-	if _, err := w.Write([]byte(fmt.Sprintln("id:", atomic.AddUint64(idCounter, 1)))); err != nil {
+	return nil
+}
+
+// writeMessageEvent writes message event, msg unless the message has event
+// type. The event id is the message id unless it is empty.
+func writeMessageEvent(idCounter *uint64, w io.Writer, id string, event string, message string,
+	expires time.Time) error {
+	if err := validateEvent(event, message); err != nil {
 		return err
 	}
-	if event != "" {
-		if _, err := w.Write([]byte(fmt.Sprintln("event:", event))); err != nil {
-			return err
-		}
+	if id == "" {
+		id = strconv.FormatUint(atomic.AddUint64(idCounter, 1), 10)
 	}
-	if _, err := w.Write([]byte(fmt.Sprintln("data:", data))); err != nil {
+	if err := writeTtlComment(w, expires); err != nil {
 		return err
 	}
-	if _, err := w.Write([]byte("\n")); err != nil {
+	return writeEventWithId(w, id, event, message)
+}
+
+// writeTtlComment writes remaining time-to-live in whole seconds of message
+// expiring at non-zero time as comment line, which EventSource ignores.
+func writeTtlComment(w io.Writer, expires time.Time) error {
+	if expires.IsZero() {
+		return nil
+	}
+	ttl := int64(math.Ceil(time.Until(expires).Seconds()))
+	_, err := w.Write([]byte(fmt.Sprintln(": ttl", ttl)))
+	return err
+}
+
+func writeShutdownEvent(idCounter *uint64, w io.Writer) error {
+	shutdownRetrySeconds := currentSettings().shutdownRetrySeconds
+	if _, err := w.Write([]byte(fmt.Sprintln("retry:", shutdownRetrySeconds*1000))); err != nil {
 		return err
 	}
-	return nil
+	return writeEvent(idCounter, w, "shutdown", fmt.Sprintf("%ds", shutdownRetrySeconds))
 }
 
 func validEventAnyChar(value string) bool {
@@ -1,12 +1,18 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPost(t *testing.T) {
@@ -22,6 +28,92 @@ func TestPost(t *testing.T) {
 	stopServing(t, server, doneServing)
 }
 
+func TestPostPreferRepresentation(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+	subscription, err := http.DefaultClient.Get(baseUrl + "/infocenter/representation-test")
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	defer subscription.Body.Close()
+	waitSubscribers(t, baseUrl, "representation-test", 1)
+
+	request, err := http.NewRequest(http.MethodPost, baseUrl+"/infocenter/representation-test",
+		bytes.NewBufferString("test message"))
+	if err != nil {
+		t.Fatalf("Got error while creating new request: %q", err)
+	}
+	request.Header.Set("Prefer", "respond-async, return=representation")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("POST failed")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusCreated)
+	}
+	var result publishResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	if result.ID == "" || result.ID != response.Header.Get(messageIdHeader) || result.Topic != "representation-test" ||
+		result.Timestamp.IsZero() || result.Subscribers != 1 {
+		t.Fatalf("Unexpected publish result %v", result)
+	}
+	if event := readServerSentEvent(t, bufio.NewReader(subscription.Body)); event.id != result.ID ||
+		event.data != "test message" {
+		t.Fatalf("Subscriber received event %q but expected id %q", event, result.ID)
+	}
+	stopServing(t, server, doneServing)
+}
+
+// readMessageData returns data of the next msg event of the stream.
+func readMessageData(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading event stream failed: %q", err)
+		}
+		if line == "event: msg\n" {
+			if line, err = reader.ReadString('\n'); err != nil {
+				t.Fatalf("Reading event stream failed: %q", err)
+			}
+			return strings.TrimSuffix(strings.TrimPrefix(line, "data: "), "\n")
+		}
+	}
+}
+
+func TestGetGroup(t *testing.T) {
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+	var readers []*bufio.Reader
+	for i := 0; i < 2; i++ {
+		response, err := http.DefaultClient.Get(baseUrl + "/infocenter/group-test?group=workers")
+		if err != nil {
+			t.Fatal("GET failed")
+		}
+		defer response.Body.Close()
+		readers = append(readers, bufio.NewReader(response.Body))
+	}
+	waitSubscribers(t, baseUrl, "group-test", 2)
+	for _, message := range []string{"job 1", "job 2"} {
+		response, err := http.DefaultClient.Post(baseUrl+"/infocenter/group-test", "text/plain",
+			bytes.NewBufferString(message))
+		if err != nil {
+			t.Fatal("POST failed")
+		}
+		_ = response.Body.Close()
+	}
+	first, second := readMessageData(t, readers[0]), readMessageData(t, readers[1])
+	if first == second || first+second != "job 1job 2" && first+second != "job 2job 1" {
+		t.Fatalf("Group members received %q and %q", first, second)
+	}
+	stopServing(t, server, doneServing)
+}
+
 func TestGetTimeout(t *testing.T) {
 	const eventStreamTimeoutSeconds = 2
 	const eventStreamTimeoutResponse = "id: 1\nevent: timeout\ndata: 2s\n\n"
@@ -162,3 +254,179 @@ func TestConcurrentWriteEventIds(t *testing.T) {
 		t.Fatalf("Unexpected ids %q, expected %q", actualIdWrites, expectedIdWrites)
 	}
 }
+
+func TestGetMetrics(t *testing.T) {
+	l, server, doneServing := listenAndServe(t)
+	postUrl := fmt.Sprintf("http://%s/infocenter/metrics-test", l.Addr().String())
+	if _, err := http.DefaultClient.Post(postUrl, "text/plain", bytes.NewBufferString("test message")); err != nil {
+		t.Fatal("POST failed")
+	}
+	metricsUrl := fmt.Sprintf("http://%s/metrics", l.Addr().String())
+	response, err := http.DefaultClient.Get(metricsUrl)
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	bodyBuffer := bytes.Buffer{}
+	if _, err := bodyBuffer.ReadFrom(response.Body); err != nil {
+		t.Fatalf("Read body failed: %q", err)
+	}
+	const expectedMetric = "infocenter_broker_published_messages_total{topic=\"metrics-test\"} 1\n"
+	if !strings.Contains(bodyBuffer.String(), expectedMetric) {
+		t.Fatalf("Metrics %q do not contain %q", bodyBuffer.String(), expectedMetric)
+	}
+
+	stopServing(t, server, doneServing)
+}
+
+func TestGetShutdown(t *testing.T) {
+	const eventStreamShutdownResponse = "retry: 5000\nid: 1\nevent: shutdown\ndata: 5s\n\n"
+	l, server, doneServing := listenAndServe(t)
+	getUrl := fmt.Sprintf("http://%s/infocenter/test", l.Addr().String())
+	response, err := http.DefaultClient.Get(getUrl)
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+
+	stopServing(t, server, doneServing)
+	if server.Handler.(countingHandler).services.eventStreamBroker.Ping(10 * time.Millisecond) {
+		t.Fatal("Expected broker to be stopped")
+	}
+
+	bodyBuffer := bytes.Buffer{}
+	if _, err := bodyBuffer.ReadFrom(response.Body); err != nil {
+		t.Fatalf("Read body failed: %q", err)
+	}
+	responseContent := bodyBuffer.String()
+	if responseContent != eventStreamShutdownResponse {
+		t.Fatalf("Unrecognized response content %q", responseContent)
+	}
+}
+
+func TestGetRetained(t *testing.T) {
+	const eventStreamRetainedResponse = "event: msg\ndata: state 2\n\nid: 1\nevent: timeout\ndata: 1s\n\n"
+	savedEventStreamTimeoutSeconds := EventStreamTimeoutSeconds
+	EventStreamTimeoutSeconds = 1
+	defer func() {
+		EventStreamTimeoutSeconds = savedEventStreamTimeoutSeconds
+	}()
+	defer setAdminToken("secret")()
+	l, server, doneServing := listenAndServe(t)
+	topicUrl := fmt.Sprintf("http://%s/infocenter/retained-test", l.Addr().String())
+	messageId := ""
+	for _, message := range []string{"state 1", "state 2"} {
+		response, err := http.DefaultClient.Post(topicUrl+"?retain=true", "text/plain",
+			bytes.NewBufferString(message))
+		if err != nil {
+			t.Fatal("POST failed")
+		}
+		if response.StatusCode != http.StatusNoContent {
+			t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+		}
+		messageId = response.Header.Get(messageIdHeader)
+	}
+	response, err := http.DefaultClient.Post(topicUrl+"?retain=maybe", "text/plain",
+		bytes.NewBufferString("state 3"))
+	if err != nil {
+		t.Fatal("POST failed")
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusBadRequest)
+	}
+
+	response, err = http.DefaultClient.Get(topicUrl)
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	bodyBuffer := bytes.Buffer{}
+	if _, err := bodyBuffer.ReadFrom(response.Body); err != nil {
+		t.Fatalf("Read body failed: %q", err)
+	}
+	if messageId == "" || bodyBuffer.String() != "id: "+messageId+"\n"+eventStreamRetainedResponse {
+		t.Fatalf("Unrecognized response content %q of message %q", bodyBuffer.String(), messageId)
+	}
+
+	retainedUrl := fmt.Sprintf("http://%s/admin/topics/retained-test/retained", l.Addr().String())
+	response = adminRequest(t, http.MethodDelete, retainedUrl, "secret")
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	response = adminRequest(t, http.MethodDelete, retainedUrl, "secret")
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNotFound)
+	}
+	stopServing(t, server, doneServing)
+}
+
+func TestGetRetainedTtl(t *testing.T) {
+	const eventStreamTtlResponse = ": ttl 30\nevent: msg\ndata: state 1\n\nevent: timeout\ndata: 1s\n\n"
+	const eventStreamExpiredResponse = "event: timeout\ndata: 1s\n\n"
+	savedEventStreamTimeoutSeconds, savedTopicTtlSeconds := EventStreamTimeoutSeconds, TopicTtlSeconds
+	EventStreamTimeoutSeconds, TopicTtlSeconds = 1, map[string]int{"ttl-test": 30}
+	defer func() {
+		EventStreamTimeoutSeconds, TopicTtlSeconds = savedEventStreamTimeoutSeconds, savedTopicTtlSeconds
+	}()
+	l, server, doneServing := listenAndServe(t)
+	topicUrl := func(topic string) string {
+		return fmt.Sprintf("http://%s/infocenter/%s", l.Addr().String(), topic)
+	}
+	post := func(url string) {
+		t.Helper()
+		response, err := http.DefaultClient.Post(url, "text/plain", bytes.NewBufferString("state 1"))
+		if err != nil {
+			t.Fatal("POST failed")
+		}
+		if response.StatusCode != http.StatusNoContent {
+			t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusNoContent)
+		}
+	}
+	assertEvents := func(url string, expectedResponse string) {
+		t.Helper()
+		response, err := http.DefaultClient.Get(url)
+		if err != nil {
+			t.Fatal("GET failed")
+		}
+		bodyBuffer := bytes.Buffer{}
+		if _, err := bodyBuffer.ReadFrom(response.Body); err != nil {
+			t.Fatalf("Read body failed: %q", err)
+		}
+		// Event ids depend on other event streams of the handler.
+		if events := eventIdRegexp.ReplaceAllString(bodyBuffer.String(), ""); events != expectedResponse {
+			t.Fatalf("Unrecognized response content %q", bodyBuffer.String())
+		}
+	}
+	post(topicUrl("ttl-test") + "?retain=true")
+	post(topicUrl("no-ttl-test") + "?retain=true&ttl=0")
+	post(topicUrl("expired-test") + "?retain=true&ttl=1")
+	assertEvents(topicUrl("ttl-test"), eventStreamTtlResponse)
+	assertEvents(topicUrl("no-ttl-test"), strings.Replace(eventStreamTtlResponse, ": ttl 30\n", "", 1))
+	assertEvents(topicUrl("expired-test"), eventStreamExpiredResponse)
+	response, err := http.DefaultClient.Get(topicUrl("ttl-test"))
+	if err != nil {
+		t.Fatal("GET failed")
+	}
+	// The stream is read seconds after posting.
+	event := readServerSentEvent(t, bufio.NewReader(response.Body))
+	if event.event != "msg" || event.data != "state 1" || len(event.comments) != 1 ||
+		!strings.HasPrefix(event.comments[0], "ttl ") {
+		t.Fatalf("Unexpected event %q", event)
+	}
+	if ttl, err := strconv.Atoi(strings.TrimPrefix(event.comments[0], "ttl ")); err != nil || ttl <= 0 || ttl > 30 {
+		t.Fatalf("Unexpected time-to-live comment %q", event.comments[0])
+	}
+	_ = response.Body.Close()
+	post(topicUrl("invalid-ttl-test") + "?ttl=1")
+	response, err = http.DefaultClient.Post(topicUrl("invalid-ttl-test")+"?ttl=-1", "text/plain",
+		bytes.NewBufferString("state 1"))
+	if err != nil {
+		t.Fatal("POST failed")
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusBadRequest)
+	}
+	stopServing(t, server, doneServing)
+}
+
+var eventIdRegexp = regexp.MustCompile(`(?m)^id: \w+\n`)
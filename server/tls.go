@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS settings enable HTTPS if TLSCertFile and TLSKeyFile are set.
+// TLSClientAuth is none, request or require.
+var (
+	TLSCertFile     = ""
+	TLSKeyFile      = ""
+	TLSClientCAFile = ""
+	TLSClientAuth   = "none"
+)
+
+// AdminIdentities is comma separated list of client certificate identities
+// allowed to use admin API without AdminToken.
+var AdminIdentities = ""
+
+// certificateCheckInterval limits how often certificate files are checked
+// for changes.
+var certificateCheckInterval = time.Second
+
+func tlsEnabled() bool {
+	return TLSCertFile != "" && TLSKeyFile != ""
+}
+
+func newTLSConfig() (*tls.Config, error) {
+	reloader, err := newCertificateReloader(TLSCertFile, TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{GetCertificate: reloader.getCertificate}
+	switch TLSClientAuth {
+	case "request":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if TLSClientCAFile != "" {
+		pem, err := os.ReadFile(TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", TLSClientCAFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// certificateReloader serves certificate loaded from files and loads it
+// again when modification time of the files changes. The old certificate
+// keeps being served if loading fails.
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modTimes    [2]time.Time
+	checked     time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	modTimes, err := reloader.fileModTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := reloader.load(modTimes); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certificateReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if time.Since(r.checked) >= certificateCheckInterval {
+		r.checked = time.Now()
+		if modTimes, err := r.fileModTimes(); err != nil {
+			log.Println("Checking certificate files failed: ", err)
+		} else if modTimes != r.modTimes {
+			if err := r.load(modTimes); err != nil {
+				log.Println("Reloading certificate failed: ", err)
+			} else {
+				log.Println("Reloaded certificate ", r.certFile)
+			}
+		}
+	}
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) fileModTimes() (modTimes [2]time.Time, err error) {
+	for i, fileName := range []string{r.certFile, r.keyFile} {
+		fileInfo, err := os.Stat(fileName)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = fileInfo.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *certificateReloader) load(modTimes [2]time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.certificate = &certificate
+	r.modTimes = modTimes
+	r.checked = time.Now()
+	return nil
+}
+
+// requestIdentity returns common name of verified client certificate.
+func requestIdentity(request *http.Request) (identity string, ok bool) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	identity = request.TLS.VerifiedChains[0][0].Subject.CommonName
+	return identity, identity != ""
+}
+
+func adminIdentity(request *http.Request, adminIdentities string) bool {
+	identity, ok := requestIdentity(request)
+	if !ok {
+		return false
+	}
+	for _, adminIdentity := range strings.Split(adminIdentities, ",") {
+		if strings.TrimSpace(adminIdentity) == identity {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPem     []byte
+	keyPem      []byte
+}
+
+// newTestCertificate returns certificate signed by issuer or self-signed
+// certificate authority if issuer is nil.
+func newTestCertificate(t *testing.T, commonName string, serialNumber int64, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generating key failed: %q", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	parent, signerKey := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signerKey = issuer.certificate, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Creating certificate failed: %q", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Parsing certificate failed: %q", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Marshalling key failed: %q", err)
+	}
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPem:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPem:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.certificate.Raw}, PrivateKey: c.key}
+}
+
+func writeTestFile(t *testing.T, fileName string, data []byte, modTime time.Time) {
+	if err := os.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatalf("Writing %s failed: %q", fileName, err)
+	}
+	if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+		t.Fatalf("Changing times of %s failed: %q", fileName, err)
+	}
+}
+
+// setTLS writes server certificate signed by ca and configures TLS
+// settings to use it.
+func setTLS(t *testing.T, ca *testCertificate, serverCertificate *testCertificate,
+	clientAuth string) (restore func()) {
+	dir := t.TempDir()
+	savedCertFile, savedKeyFile := TLSCertFile, TLSKeyFile
+	savedClientCAFile, savedClientAuth := TLSClientCAFile, TLSClientAuth
+	TLSCertFile = filepath.Join(dir, "server.crt")
+	TLSKeyFile = filepath.Join(dir, "server.key")
+	TLSClientCAFile = filepath.Join(dir, "ca.crt")
+	TLSClientAuth = clientAuth
+	now := time.Now()
+	writeTestFile(t, TLSCertFile, serverCertificate.certPem, now)
+	writeTestFile(t, TLSKeyFile, serverCertificate.keyPem, now)
+	writeTestFile(t, TLSClientCAFile, ca.certPem, now)
+	return func() {
+		TLSCertFile, TLSKeyFile = savedCertFile, savedKeyFile
+		TLSClientCAFile, TLSClientAuth = savedClientCAFile, savedClientAuth
+	}
+}
+
+func listenAndServeTLS(t *testing.T) (net.Listener, *http.Server, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("net.Listen failed")
+	}
+	server := NewServer()
+	if server.TLSConfig, err = newTLSConfig(); err != nil {
+		t.Fatalf("Creating TLS configuration failed: %q", err)
+	}
+	doneServing := make(chan error)
+	go func() {
+		doneServing <- server.ServeTLS(l, "", "")
+	}()
+	return l, server, doneServing
+}
+
+func newTLSClient(ca *testCertificate, clientCertificate *testCertificate) (*http.Client, *tls.ConnectionState) {
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.certificate)
+	tlsConfig := &tls.Config{RootCAs: rootCAs}
+	if clientCertificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{clientCertificate.tlsCertificate()}
+	}
+	connectionState := &tls.ConnectionState{}
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		*connectionState = state
+		return nil
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}},
+		connectionState
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCertificate(t, "test-ca", 1, nil)
+	defer setTLS(t, ca, newTestCertificate(t, "server", 2, ca), "none")()
+	l, server, doneServing := listenAndServeTLS(t)
+	client, _ := newTLSClient(ca, nil)
+
+	response, err := client.Get(fmt.Sprintf("https://%s/healthz", l.Addr().String()))
+	if err != nil {
+		t.Fatalf("GET failed: %q", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+	stopServing(t, server, doneServing)
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	savedCertificateCheckInterval := certificateCheckInterval
+	certificateCheckInterval = 0
+	defer func() { certificateCheckInterval = savedCertificateCheckInterval }()
+	ca := newTestCertificate(t, "test-ca", 1, nil)
+	defer setTLS(t, ca, newTestCertificate(t, "server", 2, ca), "none")()
+	l, server, doneServing := listenAndServeTLS(t)
+	client, connectionState := newTLSClient(ca, nil)
+	url := fmt.Sprintf("https://%s/healthz", l.Addr().String())
+	assertServerSerialNumber := func(expected int64) {
+		t.Helper()
+		if _, err := client.Get(url); err != nil {
+			t.Fatalf("GET failed: %q", err)
+		}
+		if serialNumber := connectionState.PeerCertificates[0].SerialNumber; serialNumber.Int64() != expected {
+			t.Fatalf("Server certificate serial number was %s but expected %d", serialNumber, expected)
+		}
+	}
+	assertServerSerialNumber(2)
+
+	renewed := newTestCertificate(t, "server", 3, ca)
+	modTime := time.Now().Add(time.Minute)
+	writeTestFile(t, TLSCertFile, renewed.certPem, modTime)
+	writeTestFile(t, TLSKeyFile, renewed.keyPem, modTime)
+	assertServerSerialNumber(3)
+
+	writeTestFile(t, TLSKeyFile, []byte("broken"), modTime.Add(time.Minute))
+	assertServerSerialNumber(3)
+	stopServing(t, server, doneServing)
+}
+
+func TestTLSAdminIdentity(t *testing.T) {
+	ca := newTestCertificate(t, "test-ca", 1, nil)
+	defer setTLS(t, ca, newTestCertificate(t, "server", 2, ca), "request")()
+	defer setAdminToken("")()
+	savedAdminIdentities := AdminIdentities
+	AdminIdentities = "operator, deployer"
+	defer func() { AdminIdentities = savedAdminIdentities }()
+	l, server, doneServing := listenAndServeTLS(t)
+	url := fmt.Sprintf("https://%s/admin/topics", l.Addr().String())
+
+	operatorClient, _ := newTLSClient(ca, newTestCertificate(t, "operator", 3, ca))
+	response, err := operatorClient.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %q", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+
+	for _, clientCertificate := range []*testCertificate{newTestCertificate(t, "subscriber", 4, ca), nil} {
+		client, _ := newTLSClient(ca, clientCertificate)
+		response, err = client.Get(url)
+		if err != nil {
+			t.Fatalf("GET failed: %q", err)
+		}
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusUnauthorized)
+		}
+	}
+	stopServing(t, server, doneServing)
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Listeners are inherited as in systemd socket activation described on
+// https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html.
+// File descriptors starting at listenFdsStart are named by LISTEN_FDNAMES.
+// Listeners named adminListenerName serve admin server, any other
+// listeners serve public server. Descriptor named upgradeReadyName is not a
+// listener but a pipe to notify the upgrading process that the upgraded
+// process serves. Descriptor named upgradeHandoverName is a pipe the
+// upgrading process closes once it stopped writing PersistenceDirectory.
+const (
+	listenFdsStart      = 3
+	adminListenerName   = "admin"
+	upgradeReadyName    = "upgrade-ready"
+	upgradeHandoverName = "upgrade-handover"
+)
+
+// upgradeReadyTimeout limits the time upgraded process takes to start
+// serving inherited listeners.
+var upgradeReadyTimeout = 30 * time.Second
+
+// upgradeReady is the pipe to notify the upgrading process by and
+// upgradeHandover the pipe it closes when it hands over. They are nil if
+// the process was not started by upgrade.
+var upgradeReady, upgradeHandover *os.File
+
+var (
+	handoverOnce sync.Once
+	handedOver   <-chan struct{}
+)
+
+// inheritListeners returns listeners passed by systemd or by upgrading
+// process. LISTEN_PID must match the process if it is set. Environment
+// variables are cleared, so that they are not inherited by child processes.
+func inheritListeners(getenv func(string) string, fdsStart int) (serverListeners, error) {
+	var listeners serverListeners
+	fds := getenv("LISTEN_FDS")
+	if fds == "" {
+		return listeners, nil
+	}
+	if pid := getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return listeners, nil
+	}
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return listeners, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(fdsStart+i), name)
+		if name == upgradeReadyName {
+			upgradeReady = file
+			continue
+		}
+		if name == upgradeHandoverName {
+			upgradeHandover = file
+			continue
+		}
+		l, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			return listeners, fmt.Errorf("inherited file descriptor %d is not a listener: %w", fdsStart+i, err)
+		}
+		if name == adminListenerName {
+			listeners.admin = append(listeners.admin, l)
+		} else {
+			listeners.public = append(listeners.public, l)
+		}
+	}
+	return listeners, nil
+}
+
+// notifyUpgradeReady tells the upgrading process that inherited listeners
+// are served, so that it may shut down.
+func notifyUpgradeReady() {
+	if upgradeReady == nil {
+		return
+	}
+	if _, err := upgradeReady.Write([]byte{1}); err != nil {
+		log.Println("Notifying upgrading process failed: ", err)
+	}
+	_ = upgradeReady.Close()
+	upgradeReady = nil
+}
+
+// upgradeHandedOver returns channel closed once the upgrading process
+// stopped services persisting to PersistenceDirectory, so that this process
+// may load their files. It is closed at once if the process was not started
+// by upgrade.
+func upgradeHandedOver() <-chan struct{} {
+	handoverOnce.Do(func() {
+		handedOver = waitClosed(upgradeHandover)
+	})
+	return handedOver
+}
+
+// waitClosed returns channel closed once the write end of the pipe is
+// closed, or at once if the pipe is nil. The pipe is closed then.
+func waitClosed(pipe *os.File) <-chan struct{} {
+	closed := make(chan struct{})
+	if pipe == nil {
+		close(closed)
+		return closed
+	}
+	go func() {
+		defer close(closed)
+		_, _ = io.Copy(io.Discard, pipe)
+		_ = pipe.Close()
+	}()
+	return closed
+}
+
+// upgradeOnSignal starts new process of the same executable with the same
+// arguments serving the listeners. Returns handover pipe if the new process
+// serves, so this one should shut down and close the pipe once its services
+// are stopped, or nil otherwise.
+func upgradeOnSignal(listeners serverListeners) (handover *os.File) {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Println("Upgrade failed: ", err)
+		return nil
+	}
+	command := exec.Command(executable, os.Args[1:]...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	handover, err = startUpgrade(command, listeners)
+	if err != nil {
+		log.Println("Upgrade failed: ", err)
+		return nil
+	}
+	log.Printf("Upgraded process %d serves, shutting down", command.Process.Pid)
+	return handover
+}
+
+// startUpgrade starts the command passing it the listeners and waits until
+// it serves them. The command is killed if it does not serve within
+// upgradeReadyTimeout. Returns write end of the handover pipe to close.
+func startUpgrade(command *exec.Cmd, listeners serverListeners) (handover *os.File, err error) {
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+	defer readyWriter.Close()
+	handoverReader, handoverWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer handoverReader.Close()
+	defer func() {
+		if err != nil {
+			_ = handoverWriter.Close()
+		}
+	}()
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+	for _, role := range []struct {
+		name      string
+		listeners []net.Listener
+	}{{"public", listeners.public}, {adminListenerName, listeners.admin}} {
+		for _, l := range role.listeners {
+			file, err := listenerFile(l)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, file)
+			names = append(names, role.name)
+		}
+	}
+	command.ExtraFiles = append(files[:len(files):len(files)], readyWriter, handoverReader)
+	names = append(names, upgradeReadyName, upgradeHandoverName)
+	environ := command.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+	command.Env = append(withoutListenEnv(environ),
+		"LISTEN_FDS="+strconv.Itoa(len(command.ExtraFiles)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"))
+	if err = command.Start(); err != nil {
+		return nil, err
+	}
+	_ = readyWriter.Close()
+	ready := make(chan error, 1)
+	go func() {
+		buffer := make([]byte, 1)
+		_, err := readyReader.Read(buffer)
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err != nil {
+			err = errors.New("upgraded process exited before serving")
+		}
+	case <-time.After(upgradeReadyTimeout):
+		err = fmt.Errorf("upgraded process did not serve within %s", upgradeReadyTimeout)
+	}
+	if err != nil {
+		_ = command.Process.Kill()
+		_ = command.Wait()
+		return nil, err
+	}
+	go func() {
+		_ = command.Wait()
+	}()
+	return handoverWriter, nil
+}
+
+// listenerFile returns duplicate of listener file descriptor. Unlike File
+// method of listeners, it keeps the shared socket in non-blocking mode
+// when the descriptor is passed to a child process, so that this process
+// keeps serving the listener.
+func listenerFile(l net.Listener) (*os.File, error) {
+	syscallConn, ok := l.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("listener %s cannot be passed", l.Addr())
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var file *os.File
+	controlErr := rawConn.Control(func(fd uintptr) {
+		var dup int
+		if dup, err = syscall.Dup(int(fd)); err == nil {
+			syscall.CloseOnExec(dup)
+			file = os.NewFile(uintptr(dup), l.Addr().String())
+		}
+	})
+	if controlErr != nil {
+		return nil, controlErr
+	}
+	return file, err
+}
+
+// withoutListenEnv returns environment without socket activation
+// variables.
+func withoutListenEnv(environ []string) []string {
+	var filtered []string
+	for _, env := range environ {
+		if !strings.HasPrefix(env, "LISTEN_PID=") && !strings.HasPrefix(env, "LISTEN_FDS=") &&
+			!strings.HasPrefix(env, "LISTEN_FDNAMES=") {
+			filtered = append(filtered, env)
+		}
+	}
+	return filtered
+}
+
+// keepUnixSockets prevents removing socket files of listeners passed to
+// upgraded process when they are closed.
+func keepUnixSockets(listeners serverListeners) {
+	for _, l := range append(append([]net.Listener(nil), listeners.public...), listeners.admin...) {
+		if unixListener, ok := l.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const upgradeHelperEnv = "GO_WANT_UPGRADE_HELPER_PROCESS"
+
+// TestUpgradeHelperProcess is not a real test but the process started by
+// upgrade tests.
+func TestUpgradeHelperProcess(t *testing.T) {
+	switch os.Getenv(upgradeHelperEnv) {
+	case "serve":
+		listeners, err := inheritListeners(os.Getenv, listenFdsStart)
+		if err != nil || len(listeners.public) == 0 {
+			os.Exit(1)
+		}
+		doneServing := make(chan error, len(listeners.public))
+		serveAll(NewServer(), listeners.public, doneServing)
+		notifyUpgradeReady()
+		<-doneServing
+		os.Exit(1)
+	case "fail":
+		os.Exit(1)
+	}
+}
+
+func upgradeHelperCommand(mode string) *exec.Cmd {
+	command := exec.Command(os.Args[0], "-test.run=^TestUpgradeHelperProcess$")
+	command.Env = append(os.Environ(), upgradeHelperEnv+"="+mode)
+	return command
+}
+
+// dupListenerFd returns a new file descriptor of the listener.
+func dupListenerFd(t *testing.T, l net.Listener) int {
+	file, err := listenerFile(l)
+	if err != nil {
+		t.Fatalf("Getting listener file failed: %q", err)
+	}
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatalf("Duplicating file descriptor failed: %q", err)
+	}
+	return fd
+}
+
+func TestInheritListeners(t *testing.T) {
+	l, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening failed: %q", err)
+	}
+	defer l.Close()
+	fd := dupListenerFd(t, l)
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": adminListenerName,
+	}
+	listeners, err := inheritListeners(func(name string) string { return env[name] }, fd)
+	if err != nil {
+		t.Fatalf("Inheriting listeners failed: %q", err)
+	}
+	if len(listeners.public) != 0 || len(listeners.admin) != 1 ||
+		listeners.admin[0].Addr().String() != l.Addr().String() {
+		t.Fatalf("Unexpected inherited listeners %v", listeners)
+	}
+	_ = listeners.admin[0].Close()
+
+	env["LISTEN_PID"] = strconv.Itoa(os.Getpid() + 1)
+	if listeners, err = inheritListeners(func(name string) string { return env[name] }, fd); err != nil ||
+		len(listeners.public)+len(listeners.admin) != 0 {
+		t.Fatalf("Inherited listeners %v of another process, error %v", listeners, err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	l, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening failed: %q", err)
+	}
+	server := NewServer()
+	doneServing := make(chan error, 1)
+	go serve(server, l, doneServing)
+	command := upgradeHelperCommand("serve")
+	handover, err := startUpgrade(command, serverListeners{public: []net.Listener{l}})
+	if err != nil {
+		t.Fatalf("Upgrade failed: %q", err)
+	}
+	defer func() { _ = command.Process.Kill() }()
+	stopServing(t, server, doneServing)
+	_ = handover.Close()
+
+	response, err := http.Get("http://" + l.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET after upgrade failed: %q", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Response code was %d but expected %d", response.StatusCode, http.StatusOK)
+	}
+}
+
+func TestFailedUpgrade(t *testing.T) {
+	savedUpgradeReadyTimeout := upgradeReadyTimeout
+	upgradeReadyTimeout = 5 * time.Second
+	defer func() { upgradeReadyTimeout = savedUpgradeReadyTimeout }()
+	l, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening failed: %q", err)
+	}
+	defer l.Close()
+	_, err = startUpgrade(upgradeHelperCommand("fail"), serverListeners{public: []net.Listener{l}})
+	if err == nil || err.Error() != "upgraded process exited before serving" {
+		t.Fatalf("Unexpected upgrade error %v", err)
+	}
+}
+
+func TestWaitClosed(t *testing.T) {
+	select {
+	case <-waitClosed(nil):
+	default:
+		t.Fatal("Expected missing pipe to be closed")
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Creating pipe failed: %q", err)
+	}
+	closed := waitClosed(reader)
+	select {
+	case <-closed:
+		t.Fatal("Unexpected handover before pipe is closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_ = writer.Close()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected handover once pipe is closed")
+	}
+}
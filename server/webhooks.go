@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/vaidasn/infocenter/chanbroker"
+	"github.com/vaidasn/infocenter/webhook"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+const (
+	// webhooksFileName is the file in PersistenceDirectory keeping webhook
+	// subscriptions.
+	webhooksFileName = "webhooks.json"
+	// maxWebhookSubscriptionSize limits posted subscription size in bytes.
+	maxWebhookSubscriptionSize = 4096
+)
+
+var WebhookOptions = webhook.DefaultOptions
+
+// newWebhookDispatcher returns dispatcher of messages to webhooks which
+// are persisted if PersistenceDirectory is set.
+func newWebhookDispatcher() *webhook.Dispatcher {
+	fileName := ""
+	if PersistenceDirectory != "" {
+		fileName = filepath.Join(PersistenceDirectory, webhooksFileName)
+	}
+	dispatcher := webhook.New(WebhookOptions, fileName)
+	go func() {
+		<-upgradeHandedOver()
+		if err := dispatcher.Load(); err != nil {
+			log.Fatal("Loading webhooks failed: ", err)
+		}
+	}()
+	return dispatcher
+}
+
+// dispatchToWebhooks passes published topic messages to the dispatcher.
+// Requests are not passed as webhooks cannot reply. Dead letters are
+// passed as messages of the dead-letter topic.
+func dispatchToWebhooks(dispatcher *webhook.Dispatcher, msg interface{}) {
+	topic := ""
+	if deadLetter, ok := msg.(chanbroker.DeadLetter); ok {
+		topic, msg = deadLetter.Topic(), deadLetter.Message
+	}
+	if published, ok := msg.(publishedMessage); ok {
+		msg = published.topicAndMessage
+	}
+	m, ok := msg.(topicAndMessage)
+	if !ok {
+		return
+	}
+	if topic == "" {
+		topic = m.topic
+	}
+	dispatcher.Dispatch(topic, m.message)
+}
+
+type adminWebhooksHandler struct {
+	webhookDispatcher *webhook.Dispatcher
+}
+
+// ServeHTTP lists webhook subscriptions without their secrets.
+func (handler adminWebhooksHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	subscriptions := handler.webhookDispatcher.Subscriptions()
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	writeJson(writer, http.StatusOK, subscriptions)
+}
+
+type adminAddWebhookHandler struct {
+	webhookDispatcher *webhook.Dispatcher
+}
+
+// ServeHTTP adds webhook subscription given by posted JSON and responds
+// with it without its secret.
+func (handler adminAddWebhookHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var subscription webhook.Subscription
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxWebhookSubscriptionSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&subscription); err != nil {
+		writeError(writer, http.StatusBadRequest, "Invalid webhook subscription: "+err.Error())
+		return
+	}
+	subscription, err := handler.webhookDispatcher.Add(subscription)
+	if errors.Is(err, webhook.ErrInvalidSubscription) {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		log.Println("Adding webhook failed: ", err)
+		writeError(writer, http.StatusInternalServerError, "Adding webhook failed")
+		return
+	}
+	subscription.Secret = ""
+	writeJson(writer, http.StatusCreated, subscription)
+}
+
+type adminRemoveWebhookHandler struct {
+	webhookDispatcher *webhook.Dispatcher
+}
+
+func (handler adminRemoveWebhookHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	removed, err := handler.webhookDispatcher.Remove(mux.Vars(request)["id"])
+	if err != nil {
+		log.Println("Removing webhook failed: ", err)
+		writeError(writer, http.StatusInternalServerError, "Removing webhook failed")
+		return
+	}
+	if !removed {
+		writeError(writer, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+type adminWebhookDeadLettersHandler struct {
+	webhookDispatcher *webhook.Dispatcher
+}
+
+// ServeHTTP lists messages not delivered to webhooks from the oldest.
+func (handler adminWebhookDeadLettersHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writeJson(writer, http.StatusOK, handler.webhookDispatcher.DeadLetters())
+}
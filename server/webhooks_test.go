@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/vaidasn/infocenter/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	defer setAdminToken("secret")()
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		if request.Header.Get(webhook.SignatureHeader) != webhook.Signature("hook-secret",
+			request.Header.Get(webhook.TimestampHeader), body) {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- request.Header.Get(webhook.TopicHeader) + ": " + string(body)
+	}))
+	defer receiver.Close()
+	l, server, doneServing := listenAndServe(t)
+	baseUrl := "http://" + l.Addr().String()
+
+	request, _ := http.NewRequest(http.MethodPost, baseUrl+"/admin/webhooks", bytes.NewBufferString(
+		`{"pattern":"hook-*","url":"`+receiver.URL+`","secret":"hook-secret"}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("POST failed: %q", err)
+	}
+	var subscription webhook.Subscription
+	if err := json.NewDecoder(response.Body).Decode(&subscription); err != nil {
+		t.Fatalf("Decoding response failed: %q", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusCreated || subscription.ID == "" || subscription.Secret != "" {
+		t.Fatalf("Unexpected response %d with subscription %v", response.StatusCode, subscription)
+	}
+
+	for _, topic := range []string{"other", "hook-test"} {
+		if statusCode := postStatusCode(t, baseUrl+"/infocenter/"+topic, "message"); statusCode !=
+			http.StatusNoContent {
+			t.Fatalf("Response code was %d but expected %d", statusCode, http.StatusNoContent)
+		}
+	}
+	select {
+	case message := <-received:
+		if message != "hook-test: message" {
+			t.Fatalf("Unexpected webhook message %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook did not receive the message")
+	}
+
+	response = adminRequest(t, http.MethodDelete, baseUrl+"/admin/webhooks/"+subscription.ID, "secret")
+	if _ = response.Body.Close(); response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Response code of removing was %d but expected %d", response.StatusCode, http.StatusNoContent)
+	}
+	stopServing(t, server, doneServing)
+}
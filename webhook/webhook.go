@@ -0,0 +1,461 @@
+// Outbound webhooks posting topic messages to target URLs.
+//
+// A subscription matches topics by a pattern as in path.Match, e.g.
+// orders.*, and every matching message is posted to its URL with a
+// timestamp and HMAC-SHA256 signature of the timestamp, a dot and the body
+// by the subscription secret, so that receivers may reject replayed
+// messages. Messages are delivered in order by a worker of each
+// subscription. Failed deliveries are retried with exponential backoff and
+// after MaxAttempts they are recorded as dead letters, like messages not
+// delivered when the subscription is removed or the dispatcher stops. If the dispatcher has a file,
+// subscriptions are written to it on every change, so that they survive
+// restarts.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vaidasn/infocenter/atomicfile"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader is sha256= followed by hex encoded HMAC-SHA256 of
+	// TimestampHeader value, a dot and the body by the subscription secret.
+	SignatureHeader = "X-Infocenter-Signature"
+	// TimestampHeader is the time of the delivery attempt in seconds since
+	// the Unix epoch.
+	TimestampHeader = "X-Infocenter-Timestamp"
+	// TopicHeader is the topic of the message.
+	TopicHeader = "X-Infocenter-Topic"
+	// DeliveryHeader is the id of the message delivery which is the same
+	// for all attempts.
+	DeliveryHeader = "X-Infocenter-Delivery"
+	// maxDeadLetters limits recorded dead letters, the oldest ones are
+	// forgotten first.
+	maxDeadLetters = 1000
+)
+
+// Subscription posts messages of topics matching Pattern to URL.
+type Subscription struct {
+	ID      string `json:"id"`
+	Pattern string `json:"pattern"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+// DeadLetter is a message not delivered after MaxAttempts.
+type DeadLetter struct {
+	SubscriptionID string    `json:"subscriptionId"`
+	URL            string    `json:"url"`
+	Topic          string    `json:"topic"`
+	Message        string    `json:"message"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error"`
+	Time           time.Time `json:"time"`
+}
+
+// Options of delivery attempts.
+type Options struct {
+	// MaxAttempts limits delivery attempts of a message.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It is doubled
+	// before every next one up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout limits a delivery attempt.
+	Timeout time.Duration
+	// QueueSize limits messages waiting for delivery by a subscription.
+	// Messages not fitting the queue are dead letters at once.
+	QueueSize int
+}
+
+var DefaultOptions = Options{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	Timeout:        10 * time.Second,
+	QueueSize:      1000,
+}
+
+var (
+	// ErrInvalidSubscription is returned by Add for subscription with
+	// invalid pattern or URL or without secret.
+	ErrInvalidSubscription = errors.New("invalid webhook subscription")
+	// errStopped is the error of dead letters not delivered as their
+	// subscription was removed or the dispatcher stopped.
+	errStopped = errors.New("delivery stopped")
+)
+
+type delivery struct {
+	id      string
+	topic   string
+	message string
+}
+
+// worker delivers messages of a subscription.
+type worker struct {
+	subscription Subscription
+	queue        chan delivery
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+type Dispatcher struct {
+	options     Options
+	fileName    string
+	client      *http.Client
+	mutex       sync.Mutex
+	workers     map[string]*worker
+	deadLetters []DeadLetter
+	stopped     bool
+	wg          sync.WaitGroup
+	// persistMutex serializes changes of subscriptions, so that the file is
+	// written in their order without holding mutex, which Dispatch needs.
+	// It also guards loaded.
+	persistMutex sync.Mutex
+	loaded       bool
+}
+
+// New returns dispatcher with subscriptions persisted to fileName unless
+// it is empty, once Load is called.
+func New(options Options, fileName string) *Dispatcher {
+	return &Dispatcher{
+		options:  options,
+		fileName: fileName,
+		client:   &http.Client{Timeout: options.Timeout},
+		workers:  map[string]*worker{},
+	}
+}
+
+// Load loads subscriptions persisted earlier and starts their workers.
+// Subscriptions added since New are persisted then, as the file is not
+// written before Load.
+func (d *Dispatcher) Load() error {
+	d.persistMutex.Lock()
+	defer d.persistMutex.Unlock()
+	var subscriptions []Subscription
+	if d.fileName != "" {
+		data, err := os.ReadFile(d.fileName)
+		if err == nil {
+			err = json.Unmarshal(data, &subscriptions)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d.mutex.Lock()
+	addedSinceNew := len(d.workers) != 0
+	if !d.stopped {
+		for _, subscription := range subscriptions {
+			d.startWorker(subscription)
+		}
+	}
+	subscriptions = d.subscriptions()
+	d.mutex.Unlock()
+	d.loaded = true
+	if addedSinceNew {
+		return d.persist(subscriptions)
+	}
+	return nil
+}
+
+// Add validates the subscription, assigns it a new id and starts
+// delivering messages to it.
+func (d *Dispatcher) Add(subscription Subscription) (Subscription, error) {
+	if _, err := path.Match(subscription.Pattern, ""); err != nil || subscription.Pattern == "" {
+		return Subscription{}, fmt.Errorf("%w: pattern %q", ErrInvalidSubscription, subscription.Pattern)
+	}
+	if target, err := url.Parse(subscription.URL); err != nil ||
+		target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+		return Subscription{}, fmt.Errorf("%w: url %q", ErrInvalidSubscription, subscription.URL)
+	}
+	if subscription.Secret == "" {
+		return Subscription{}, fmt.Errorf("%w: secret is required", ErrInvalidSubscription)
+	}
+	d.persistMutex.Lock()
+	defer d.persistMutex.Unlock()
+	d.mutex.Lock()
+	stopped, subscriptions := d.stopped, d.subscriptions()
+	d.mutex.Unlock()
+	if stopped {
+		return Subscription{}, errStopped
+	}
+	subscription.ID = newID()
+	if err := d.persist(append(subscriptions, subscription)); err != nil {
+		return Subscription{}, err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stopped {
+		return Subscription{}, errStopped
+	}
+	d.startWorker(subscription)
+	return subscription, nil
+}
+
+// Remove stops delivering messages to the subscription. Messages waiting
+// for delivery are recorded as dead letters. Returns false if there is no
+// such subscription.
+func (d *Dispatcher) Remove(id string) (bool, error) {
+	d.persistMutex.Lock()
+	defer d.persistMutex.Unlock()
+	d.mutex.Lock()
+	_, ok := d.workers[id]
+	subscriptions := d.subscriptions()
+	d.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+	remaining := subscriptions[:0]
+	for _, subscription := range subscriptions {
+		if subscription.ID != id {
+			remaining = append(remaining, subscription)
+		}
+	}
+	if err := d.persist(remaining); err != nil {
+		return false, err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	w := d.workers[id]
+	delete(d.workers, id)
+	w.cancel()
+	d.drain(w)
+	return true, nil
+}
+
+// Subscriptions returns subscriptions ordered by id.
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mutex.Lock()
+	subscriptions := d.subscriptions()
+	d.mutex.Unlock()
+	sortByID(subscriptions)
+	return subscriptions
+}
+
+func (d *Dispatcher) subscriptions() []Subscription {
+	subscriptions := make([]Subscription, 0, len(d.workers))
+	for _, w := range d.workers {
+		subscriptions = append(subscriptions, w.subscription)
+	}
+	return subscriptions
+}
+
+func sortByID(subscriptions []Subscription) {
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+}
+
+// DeadLetters returns recorded dead letters from the oldest.
+func (d *Dispatcher) DeadLetters() []DeadLetter {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]DeadLetter{}, d.deadLetters...)
+}
+
+// Dispatch queues the message for delivery to subscriptions matching the
+// topic. It does not block. After Stop the message is recorded as dead
+// letter.
+func (d *Dispatcher) Dispatch(topic string, message string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, w := range d.workers {
+		if matched, _ := path.Match(w.subscription.Pattern, topic); !matched {
+			continue
+		}
+		if d.stopped {
+			d.addDeadLetter(w.subscription, topic, message, 0, errStopped)
+			continue
+		}
+		select {
+		case w.queue <- delivery{id: newID(), topic: topic, message: message}:
+		default:
+			d.addDeadLetter(w.subscription, topic, message, 0, errors.New("delivery queue is full"))
+		}
+	}
+}
+
+// Stop stops all workers and waits until they return. Messages waiting
+// for delivery or retry are recorded as dead letters.
+func (d *Dispatcher) Stop() {
+	d.mutex.Lock()
+	d.stopped = true
+	for _, w := range d.workers {
+		w.cancel()
+	}
+	d.mutex.Unlock()
+	d.wg.Wait()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, w := range d.workers {
+		d.drain(w)
+	}
+}
+
+// drain records messages waiting in the queue of the stopped worker as
+// dead letters.
+func (d *Dispatcher) drain(w *worker) {
+	for {
+		select {
+		case m := <-w.queue:
+			d.addDeadLetter(w.subscription, m.topic, m.message, 0, errStopped)
+		default:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) startWorker(subscription Subscription) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &worker{
+		subscription: subscription,
+		queue:        make(chan delivery, d.options.QueueSize),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	d.workers[subscription.ID] = w
+	d.wg.Add(1)
+	go d.run(w)
+}
+
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case m := <-w.queue:
+			d.deliver(w, m)
+		}
+	}
+}
+
+// deliver posts the message until it succeeds, MaxAttempts are made or the
+// worker is stopped. Messages not delivered are recorded as dead letters.
+func (d *Dispatcher) deliver(w *worker, m delivery) {
+	backoff := d.options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if w.ctx.Err() != nil {
+			d.giveUp(w, m, attempt-1, errStopped)
+			return
+		}
+		err := d.post(w, m)
+		if err == nil {
+			return
+		}
+		if w.ctx.Err() != nil {
+			err = errStopped
+		}
+		if attempt >= d.options.MaxAttempts || err == errStopped {
+			d.giveUp(w, m, attempt, err)
+			return
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-w.ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > d.options.MaxBackoff {
+			backoff = d.options.MaxBackoff
+		}
+	}
+}
+
+// giveUp records the message not delivered after the attempts as dead
+// letter.
+func (d *Dispatcher) giveUp(w *worker, m delivery, attempts int, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.addDeadLetter(w.subscription, m.topic, m.message, attempts, err)
+}
+
+func (d *Dispatcher) post(w *worker, m delivery) error {
+	body := []byte(m.message)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	request.Header.Set(TopicHeader, m.topic)
+	request.Header.Set(DeliveryHeader, m.id)
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set(SignatureHeader, Signature(w.subscription.Secret, timestamp, body))
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("response status %s", response.Status)
+	}
+	return nil
+}
+
+// Signature returns value of SignatureHeader for the body posted at the
+// timestamp.
+func Signature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) addDeadLetter(subscription Subscription, topic string, message string, attempts int,
+	err error) {
+	log.Printf("Webhook %s gave up delivering message of topic %s: %s", subscription.ID, topic, err)
+	d.deadLetters = append(d.deadLetters, DeadLetter{
+		SubscriptionID: subscription.ID,
+		URL:            subscription.URL,
+		Topic:          topic,
+		Message:        message,
+		Attempts:       attempts,
+		Error:          err.Error(),
+		Time:           time.Now(),
+	})
+	if len(d.deadLetters) > maxDeadLetters {
+		d.deadLetters = d.deadLetters[len(d.deadLetters)-maxDeadLetters:]
+	}
+}
+
+// persist writes the subscriptions unless there is no file or it is not
+// loaded yet, as another process may still own it then. Requires
+// persistMutex.
+func (d *Dispatcher) persist(subscriptions []Subscription) error {
+	if d.fileName == "" || !d.loaded {
+		return nil
+	}
+	sortByID(subscriptions)
+	return atomicfile.WriteJSON(d.fileName, subscriptions)
+}
+
+func newID() string {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		log.Println("Generating webhook id failed: ", err)
+		return ""
+	}
+	return hex.EncodeToString(buffer)
+}
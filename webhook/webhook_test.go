@@ -0,0 +1,185 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     20 * time.Millisecond,
+	Timeout:        time.Second,
+	QueueSize:      10,
+}
+
+func TestDispatcher(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan *http.Request, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		if Signature("secret", request.Header.Get(TimestampHeader), body) != request.Header.Get(SignatureHeader) ||
+			string(body) != "message" {
+			t.Errorf("Unexpected body %q with signature %s", body, request.Header.Get(SignatureHeader))
+		}
+		// The first attempt fails, so that the message is retried.
+		if attempts.Add(1) == 1 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- request
+	}))
+	defer receiver.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	d := New(testOptions, "")
+	defer d.Stop()
+	if _, err := d.Add(Subscription{Pattern: "orders.[", URL: receiver.URL, Secret: "secret"}); err == nil {
+		t.Fatal("Expected invalid pattern to be rejected")
+	}
+	if _, err := d.Add(Subscription{Pattern: "orders.*", URL: "ftp://localhost", Secret: "secret"}); err == nil {
+		t.Fatal("Expected invalid url to be rejected")
+	}
+	if _, err := d.Add(Subscription{Pattern: "orders.*", URL: receiver.URL}); err == nil {
+		t.Fatal("Expected subscription without secret to be rejected")
+	}
+	subscription, err := d.Add(Subscription{Pattern: "orders.*", URL: receiver.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Add failed: %q", err)
+	}
+	failingSubscription, _ := d.Add(Subscription{Pattern: "orders.eu", URL: failing.URL, Secret: "secret"})
+
+	d.Dispatch("alerts", "message")
+	d.Dispatch("orders.eu", "message")
+	select {
+	case request := <-received:
+		if request.Header.Get(TopicHeader) != "orders.eu" || request.Header.Get(DeliveryHeader) == "" {
+			t.Fatalf("Unexpected headers %v", request.Header)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Message was not delivered")
+	}
+	for deadline := time.Now().Add(2 * time.Second); len(d.DeadLetters()) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected dead letter of failing webhook")
+		}
+	}
+	deadLetters := d.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].SubscriptionID != failingSubscription.ID ||
+		deadLetters[0].Attempts != 3 || deadLetters[0].Message != "message" {
+		t.Fatalf("Unexpected dead letters %v", deadLetters)
+	}
+	if removed, _ := d.Remove(subscription.ID); !removed {
+		t.Fatal("Expected subscription to be removed")
+	}
+	if subscriptions := d.Subscriptions(); len(subscriptions) != 1 || subscriptions[0].ID != failingSubscription.ID {
+		t.Fatalf("Unexpected subscriptions %v", subscriptions)
+	}
+}
+
+func TestDispatcherPersistence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "webhooks.json")
+	d := New(testOptions, fileName)
+	if err := d.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	subscription, err := d.Add(Subscription{Pattern: "*", URL: "http://localhost/hook", Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Add failed: %q", err)
+	}
+	d.Stop()
+
+	d = New(testOptions, fileName)
+	defer d.Stop()
+	added, err := d.Add(Subscription{Pattern: "*", URL: "http://localhost/added", Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Add failed: %q", err)
+	}
+	if err := d.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	if subscriptions := d.Subscriptions(); len(subscriptions) != 2 {
+		t.Fatalf("Unexpected persisted subscriptions %v", subscriptions)
+	}
+
+	d = New(testOptions, fileName)
+	defer d.Stop()
+	if err := d.Load(); err != nil {
+		t.Fatalf("Load failed: %q", err)
+	}
+	subscriptions := d.Subscriptions()
+	if len(subscriptions) != 2 || subscriptions[0] != subscription && subscriptions[1] != subscription ||
+		subscriptions[0] != added && subscriptions[1] != added {
+		t.Fatalf("Unexpected persisted subscriptions %v", subscriptions)
+	}
+}
+
+func TestDispatcherRemove(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer receiver.Close()
+	defer close(release)
+	d := New(testOptions, "")
+	defer d.Stop()
+	subscription, err := d.Add(Subscription{Pattern: "*", URL: receiver.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Add failed: %q", err)
+	}
+	d.Dispatch("orders", "posting")
+	<-received
+	d.Dispatch("orders", "queued")
+	if removed, _ := d.Remove(subscription.ID); !removed {
+		t.Fatal("Expected subscription to be removed")
+	}
+	for deadline := time.Now().Add(2 * time.Second); len(d.DeadLetters()) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected dead letters %v", d.DeadLetters())
+		}
+	}
+	for _, deadLetter := range d.DeadLetters() {
+		if deadLetter.Error != errStopped.Error() {
+			t.Fatalf("Unexpected dead letter %v", deadLetter)
+		}
+	}
+}
+
+func TestDispatcherStop(t *testing.T) {
+	received := make(chan struct{}, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+	options := testOptions
+	options.InitialBackoff = time.Minute
+	options.MaxBackoff = time.Minute
+	d := New(options, "")
+	if _, err := d.Add(Subscription{Pattern: "*", URL: receiver.URL, Secret: "secret"}); err != nil {
+		t.Fatalf("Add failed: %q", err)
+	}
+	d.Dispatch("orders", "retried")
+	<-received
+	d.Dispatch("orders", "queued")
+	d.Stop()
+	d.Dispatch("orders", "stopped")
+	deadLetters := d.DeadLetters()
+	if len(deadLetters) != 3 || deadLetters[0].Message != "retried" || deadLetters[0].Attempts != 1 ||
+		deadLetters[1].Message != "queued" || deadLetters[1].Attempts != 0 || deadLetters[1].Error != errStopped.Error() ||
+		deadLetters[2].Message != "stopped" || deadLetters[2].Error != errStopped.Error() {
+		t.Fatalf("Unexpected dead letters %v", deadLetters)
+	}
+}